@@ -3,9 +3,13 @@
 package main
 
 import (
+	"bufio"
 	"github.com/sirupsen/logrus"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
 	parser2 "gitlab.com/slon/shad-go/gitfame/pkg/parser"
+	"gitlab.com/slon/shad-go/gitfame/pkg/progress"
 	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"gitlab.com/slon/shad-go/gitfame/pkg/server"
 	"os"
 )
 
@@ -17,20 +21,191 @@ func main() {
 	Log.SetLevel(logrus.DebugLevel)
 	//Log.Debug("start parse")
 	args := os.Args[1:]
-	Scaner.Scan(args)
+	scaner.Completions = parser2.Completions()
+	if err := Scaner.Scan(args); err != nil {
+		exit(err)
+	}
+	switch Scaner.Command {
+	case "help":
+		return
+	case "serve":
+		exit(server.Run(&Scaner))
+	case "codeowners":
+		exit(runCodeowners())
+	case "codeowners check":
+		exit(runCodeownersCheck())
+	default:
+		exit(run())
+	}
+}
+
+// exit terminates with the exit code documented for err.
+func exit(err error) {
+	if err != nil {
+		Log.Error(err)
+	}
+	os.Exit(errs.ExitCode(err))
+}
+
+// validateFlags checks the flags with validate, the options of the command,
+// and the file filters and progress flags shared by all commands. It reads no
+// repository, so a mistyped flag fails before any clone or git command.
+func validateFlags(validate func() error) error {
+	if err := parser2.ValidateFilters(&Scaner); err != nil {
+		return err
+	}
+	if _, err := progress.New(Scaner.Progress, Scaner.ProgressFormat, os.Stderr); err != nil {
+		return err
+	}
+	return validate()
+}
+
+// prepare validates the flags, opens the repositories, applies the
+// configuration of the first one and validates the flags it set;
+// cleanup must be called even on error.
+func prepare(validate func() error) ([]parser2.RepoSpec, func(), progress.Reporter, error) {
+	if err := validateFlags(validate); err != nil {
+		return nil, func() {}, nil, err
+	}
+	specs, cleanup, err := parser2.OpenRepositories(&Scaner)
+	if err != nil {
+		return nil, cleanup, nil, err
+	}
+	setsFlags, err := parser2.ApplyConfigs(&Scaner, specs)
+	if err != nil {
+		return nil, cleanup, nil, err
+	}
+	if setsFlags {
+		if err := validateFlags(validate); err != nil {
+			return nil, cleanup, nil, err
+		}
+	}
+	if err := parser2.ValidateSpecs(&Scaner, specs); err != nil {
+		return nil, cleanup, nil, err
+	}
+	if err := parser2.ReadFilesFrom(&Scaner, os.Stdin); err != nil {
+		return nil, cleanup, nil, err
+	}
+	reporter, err := progress.New(Scaner.Progress, Scaner.ProgressFormat, os.Stderr)
+	if err != nil {
+		return nil, cleanup, nil, err
+	}
+	return specs, cleanup, reporter, nil
+}
+
+func run() error {
 	//Log.Debug("start routine")
-	parser := parser2.NewParser(&Scaner)
-	errs := parser.DoRoutine()
+	var formatter parser2.Formatter
+	specs, cleanup, reporter, err := prepare(func() (err error) {
+		formatter, err = parser2.NewFormatter(&Scaner)
+		return err
+	})
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	if db, ok := formatter.(*parser2.SQLiteFormatter); ok {
+		defer db.Close()
+		if err := db.Open(&Scaner, specs); err != nil {
+			return err
+		}
+	}
+	if tf, ok := formatter.(*parser2.TemplateFormatter); ok {
+		if err := tf.Open(&Scaner, specs); err != nil {
+			return err
+		}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	var onHunk func(parser2.BlameHunk) error
+	if hunks, ok := formatter.(parser2.HunkWriter); ok {
+		onHunk = hunks.Hunks(out)
+	}
+	stats, skipped, err := parser2.CollectStats(&Scaner, specs, reporter, onHunk)
 	//Log.Debug("finish routine")
-	if errs != nil {
-		Log.Fatal(errs)
+	if err != nil {
+		return err
 	}
-	formatter, err := parser2.NewFormatter(parser.Scaner.Format, parser.Scaner.OrderBy)
+	formatter.SetSkipped(skipped)
+	if err := formatter.Output(out, stats); err != nil {
+		if errs.ExitCode(err) != errs.ExitFailure {
+			return err
+		}
+		return &errs.OutputError{Err: err}
+	}
+	if err := out.Flush(); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	return nil
+}
+
+func runCodeowners() error {
+	var opts *parser2.CodeownersOptions
+	specs, cleanup, reporter, err := prepare(func() (err error) {
+		opts, err = parser2.NewCodeownersOptions(&Scaner)
+		return err
+	})
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	if len(specs) != 1 {
+		return errs.InvalidFlag("repository", "codeowners analyzes a single repository")
+	}
+	files, stats, err := parser2.CollectOwnership(&Scaner, specs[0], reporter)
 	if err != nil {
-		Log.Fatal(err)
+		return err
 	}
-	err = formatter.Output(parser.Stats)
+	rules, err := parser2.GenerateCodeowners(files, stats, opts)
 	if err != nil {
-		Log.Fatal(err)
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	header := "Generated by gitfame codeowners from " + specs[0].Scaner(&Scaner).Revision
+	if err := parser2.WriteCodeowners(out, header, rules); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	if err := out.Flush(); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	return nil
+}
+
+func runCodeownersCheck() error {
+	var handles map[string]string
+	specs, cleanup, reporter, err := prepare(func() (err error) {
+		if Scaner.MaxDrift < 0 || Scaner.MaxDrift > 1 {
+			return errs.InvalidFlag("max-drift", "must be in [0, 1], got %v", Scaner.MaxDrift)
+		}
+		handles, err = parser2.LoadHandles(Scaner.Handles)
+		return err
+	})
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	if len(specs) != 1 {
+		return errs.InvalidFlag("repository", "codeowners check analyzes a single repository")
+	}
+	scan := specs[0].Scaner(&Scaner)
+	name, rules, err := parser2.LoadCodeowners(scan)
+	if err != nil {
+		return err
+	}
+	files, stats, err := parser2.CollectOwnership(&Scaner, specs[0], reporter)
+	if err != nil {
+		return err
+	}
+	drift := parser2.CheckCodeowners(files, stats, rules, handles, Scaner.Teams)
+	out := bufio.NewWriter(os.Stdout)
+	header := "Checked " + name + " at " + scan.Revision
+	if err := parser2.WriteDrift(out, header, drift, len(files)); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	if err := out.Flush(); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	if float64(len(drift)) > Scaner.MaxDrift*float64(len(files)) {
+		return &errs.DriftError{Drifted: len(drift), Total: len(files), MaxDrift: Scaner.MaxDrift}
 	}
+	return nil
 }
@@ -0,0 +1,160 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Exit codes of gitfame.
+const (
+	ExitOK              = 0
+	ExitFailure         = 1 // any other error
+	ExitInvalidFlags    = 2
+	ExitNotRepository   = 3
+	ExitUnknownRevision = 4
+	ExitGitFailure      = 5
+	ExitOutputFailure   = 6
+	ExitOwnershipDrift  = 7
+)
+
+// InvalidFlagError is an invalid command line flag or flag combination.
+type InvalidFlagError struct {
+	Flag string // empty if the error is not about a single flag
+	Err  error
+}
+
+func (e *InvalidFlagError) Error() string {
+	if e.Flag == "" {
+		return fmt.Sprintf("invalid flags: %v", e.Err)
+	}
+	return fmt.Sprintf("invalid --%s: %v", e.Flag, e.Err)
+}
+
+func (e *InvalidFlagError) Unwrap() error { return e.Err }
+
+func InvalidFlag(flag string, format string, args ...any) error {
+	return &InvalidFlagError{Flag: flag, Err: fmt.Errorf(format, args...)}
+}
+
+// PathspecError is an invalid pathspec or a positional argument given
+// before --. It is reported as an invalid flag.
+type PathspecError struct {
+	Pathspecs []string
+	Err       error
+}
+
+func (e *PathspecError) Error() string {
+	return fmt.Sprintf("invalid pathspecs %q: %v", e.Pathspecs, e.Err)
+}
+
+func (e *PathspecError) Unwrap() error { return e.Err }
+
+// NotRepositoryError means that Path is not a git repository.
+type NotRepositoryError struct {
+	Path string
+	Err  error
+}
+
+func (e *NotRepositoryError) Error() string {
+	return fmt.Sprintf("%s is not a git repository: %v", e.Path, e.Err)
+}
+
+func (e *NotRepositoryError) Unwrap() error { return e.Err }
+
+// UnknownRevisionError means that Revision does not name a commit in Repository.
+type UnknownRevisionError struct {
+	Repository string
+	Revision   string
+}
+
+func (e *UnknownRevisionError) Error() string {
+	return fmt.Sprintf("unknown revision %q in %s", e.Revision, e.Repository)
+}
+
+// GitError is a failed git command.
+type GitError struct {
+	Args   []string // command line including "git"
+	Dir    string   // working directory, empty for the current one
+	Stderr string
+	Err    error
+}
+
+func (e *GitError) Error() string {
+	msg := strings.TrimSpace(e.Stderr)
+	if msg == "" {
+		msg = e.Err.Error()
+	}
+	cmd := strings.Join(e.Args, " ")
+	if e.Dir != "" {
+		return fmt.Sprintf("%s (in %s): %s", cmd, e.Dir, msg)
+	}
+	return fmt.Sprintf("%s: %s", cmd, msg)
+}
+
+func (e *GitError) Unwrap() error { return e.Err }
+
+// FileError is a git failure while processing a specific file.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// OutputError is a failure to write the results.
+type OutputError struct {
+	Err error
+}
+
+func (e *OutputError) Error() string {
+	return fmt.Sprintf("write output: %v", e.Err)
+}
+
+func (e *OutputError) Unwrap() error { return e.Err }
+
+// DriftError means that too many files drifted from their declared owners.
+type DriftError struct {
+	Drifted  int     // files without an owner rule or without lines of their owners
+	Total    int     // checked files
+	MaxDrift float64 // allowed share of drifted files
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("%d of %d files drifted from CODEOWNERS (%.1f%%), allowed %.1f%%",
+		e.Drifted, e.Total, 100*float64(e.Drifted)/float64(e.Total), 100*e.MaxDrift)
+}
+
+// ExitCode maps err to the exit code of gitfame.
+func ExitCode(err error) int {
+	var (
+		invalidFlag     *InvalidFlagError
+		pathspecErr     *PathspecError
+		notRepository   *NotRepositoryError
+		unknownRevision *UnknownRevisionError
+		gitErr          *GitError
+		outputErr       *OutputError
+		driftErr        *DriftError
+	)
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &invalidFlag), errors.As(err, &pathspecErr):
+		return ExitInvalidFlags
+	case errors.As(err, &notRepository):
+		return ExitNotRepository
+	case errors.As(err, &unknownRevision):
+		return ExitUnknownRevision
+	case errors.As(err, &outputErr):
+		return ExitOutputFailure
+	case errors.As(err, &driftErr):
+		return ExitOwnershipDrift
+	case errors.As(err, &gitErr):
+		return ExitGitFailure
+	}
+	return ExitFailure
+}
@@ -0,0 +1,67 @@
+// Package gittest builds git repositories for tests.
+package gittest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Repo is a temporary git repository built by a test.
+type Repo struct {
+	TB  testing.TB
+	Dir string
+}
+
+// New creates an empty repository in a temporary directory.
+func New(tb testing.TB) *Repo {
+	return Init(tb, tb.TempDir())
+}
+
+// Init creates an empty repository in dir with the branch main.
+// The test is skipped if git is not installed.
+func Init(tb testing.TB, dir string) *Repo {
+	tb.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		tb.Skip("git is not installed")
+	}
+	require.NoError(tb, os.MkdirAll(dir, 0o755))
+	r := &Repo{TB: tb, Dir: dir}
+	r.Git("init", "-q", "-b", "main")
+	return r
+}
+
+// Git runs git in the repository and returns its trimmed output.
+func (r *Repo) Git(args ...string) string {
+	r.TB.Helper()
+	// local submodules are only cloned with the file protocol allowed
+	cmd := exec.Command("git", append([]string{"-c", "protocol.file.allow=always"}, args...)...)
+	cmd.Dir = r.Dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+r.TB.TempDir())
+	out, err := cmd.CombinedOutput()
+	require.NoError(r.TB, err, "git %v: %s", args, out)
+	return strings.TrimSpace(string(out))
+}
+
+// Write writes the files of the work tree, paths mapped to contents.
+func (r *Repo) Write(files map[string]string) {
+	r.TB.Helper()
+	for name, content := range files {
+		path := filepath.Join(r.Dir, name)
+		require.NoError(r.TB, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(r.TB, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+// Commit writes and commits files as author, whose email is derived from the name.
+func (r *Repo) Commit(author string, files map[string]string) {
+	r.TB.Helper()
+	r.Write(files)
+	r.Git("add", "-A")
+	email := strings.ToLower(strings.ReplaceAll(author, " ", ".")) + "@example.com"
+	r.Git("-c", "user.name="+author, "-c", "user.email="+email, "commit", "-q", "-m", "commit by "+author)
+}
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ageUnits are the units of --age-buckets in days.
+var ageUnits = map[byte]int{'d': 1, 'w': 7, 'm': 30, 'y': 365}
+
+// AgeColumns parses --age-buckets, e.g. "1m,6m,1y", into columns with the
+// number of lines younger than each bound and a column for the older lines.
+func AgeColumns(buckets string) ([]Column, error) {
+	type bound struct {
+		name string
+		days int
+	}
+	var bounds []bound
+	for _, name := range SplitByDot(buckets) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return nil, errs.InvalidFlag("age-buckets", "empty bucket in %q", buckets)
+		}
+		unit, ok := ageUnits[name[len(name)-1]]
+		n, err := strconv.Atoi(name[:len(name)-1])
+		if !ok || err != nil || n <= 0 {
+			return nil, errs.InvalidFlag("age-buckets", "invalid age %q, use a number with d, w, m or y", name)
+		}
+		bounds = append(bounds, bound{name, n * unit})
+	}
+	if len(bounds) == 0 {
+		return nil, errs.InvalidFlag("age-buckets", "no buckets in %q", buckets)
+	}
+	sort.SliceStable(bounds, func(i, j int) bool { return bounds[i].days < bounds[j].days })
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i].days == bounds[i-1].days {
+			return nil, errs.InvalidFlag("age-buckets", "bounds %s and %s are both %d days", bounds[i-1].name, bounds[i].name, bounds[i].days)
+		}
+	}
+
+	var columns []Column
+	from := 0
+	for _, b := range bounds {
+		columns = append(columns, ageColumn("lt_"+b.name, "<"+b.name, from, b.days))
+		from = b.days
+	}
+	last := bounds[len(bounds)-1]
+	return append(columns, ageColumn("ge_"+last.name, ">="+last.name, from, -1)), nil
+}
+
+// ageColumn counts lines aged from <= days < to; a negative to means no upper bound.
+func ageColumn(key, header string, from, to int) Column {
+	return Column{
+		Key:    "age_" + key,
+		Header: "Age" + header,
+		Total:  true,
+		Value: func(r StatsShare) any {
+			lines := 0
+			for days, n := range r.Ages {
+				if days >= from && (to < 0 || days < to) {
+					lines += n
+				}
+			}
+			return lines
+		},
+	}
+}
+
+// medianAge returns the median age of lines in days.
+func medianAge(ages map[int]int) int {
+	total := 0
+	days := make([]int, 0, len(ages))
+	for d, n := range ages {
+		total += n
+		days = append(days, d)
+	}
+	sort.Ints(days)
+	seen := 0
+	for _, d := range days {
+		seen += ages[d]
+		if 2*seen >= total {
+			return d
+		}
+	}
+	return 0
+}
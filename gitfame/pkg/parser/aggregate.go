@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/progress"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+)
+
+// Groupings are the values accepted by --by.
+var Groupings = []string{"author", "repo", "team"}
+
+// RepoSpec is a repository of a multi-repository run.
+// Non-empty fields override the corresponding command line flags.
+type RepoSpec struct {
+	Path       string `yaml:"path"`
+	Name       string `yaml:"name"`
+	Revision   string `yaml:"revision"`
+	Extensions string `yaml:"extensions"`
+	Languages  string `yaml:"languages"`
+	Exclude    string `yaml:"exclude"`
+	RestrictTo string `yaml:"restrict-to"`
+
+	Dir     string              `yaml:"-"` // repository to run git in; differs from Path for bundles
+	Aliases map[string][]string `yaml:"-"` // aliases of the repository's .gitfame.yml in multi-repository runs
+}
+
+type Manifest struct {
+	Repositories []RepoSpec `yaml:"repositories"`
+}
+
+// LoadManifest reads a manifest; relative paths are resolved against its directory.
+func LoadManifest(path string) ([]RepoSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errs.InvalidFlag("manifest", "%w", err)
+	}
+	var manifest Manifest
+	if err := yaml.UnmarshalStrict(data, &manifest); err != nil {
+		return nil, errs.InvalidFlag("manifest", "%s: %w", path, err)
+	}
+	for i, spec := range manifest.Repositories {
+		if spec.Path == "" {
+			return nil, errs.InvalidFlag("manifest", "%s: repository #%d has no path", path, i+1)
+		}
+		if !filepath.IsAbs(spec.Path) {
+			manifest.Repositories[i].Path = filepath.Join(filepath.Dir(path), spec.Path)
+		}
+	}
+	return manifest.Repositories, nil
+}
+
+// RepoSpecs lists the repositories given with --repository and --manifest.
+func RepoSpecs(scan *scaner.Scaner) ([]RepoSpec, error) {
+	var specs []RepoSpec
+	for _, path := range scan.Repositories {
+		specs = append(specs, RepoSpec{Path: path})
+	}
+	if scan.Manifest != "" {
+		manifestSpecs, err := LoadManifest(scan.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, manifestSpecs...)
+	}
+	if len(specs) == 0 {
+		return nil, errs.InvalidFlag("repository", "no repositories to analyze")
+	}
+	return specs, nil
+}
+
+// Label returns the name of the repository used in --by=repo rows and file paths.
+func (spec RepoSpec) Label() string {
+	if spec.Name != "" {
+		return spec.Name
+	}
+	if abs, err := filepath.Abs(spec.Path); err == nil {
+		return bundleLabel(filepath.Base(abs))
+	}
+	return bundleLabel(spec.Path)
+}
+
+// Scaner returns a copy of base set up for analyzing the repository.
+func (spec RepoSpec) Scaner(base *scaner.Scaner) *scaner.Scaner {
+	scan := *base
+	scan.Repository = spec.Path
+	if spec.Dir != "" {
+		scan.Repository = spec.Dir
+	}
+	override := func(dst *string, value string) {
+		if value != "" {
+			*dst = value
+		}
+	}
+	override(&scan.Revision, spec.Revision)
+	override(&scan.Extensions, spec.Extensions)
+	override(&scan.Languages, spec.Languages)
+	override(&scan.Exclude, spec.Exclude)
+	override(&scan.RestrictTo, spec.RestrictTo)
+	if spec.Aliases != nil {
+		scan.Aliases = spec.Aliases
+	}
+	return &scan
+}
+
+// MergeStats adds stats of the repository labeled repo to dst.
+// Rows are keyed by author, or by author and repository for --by=repo.
+func MergeStats(dst, src map[string]*AuthorStats, repo string, by string) {
+	for author, stats := range src {
+		key := author
+		if by == "repo" {
+			key = repo + "\x00" + author
+		}
+		if _, ok := dst[key]; !ok {
+			dst[key] = NewAuthorStats()
+			dst[key].Name = author
+			if by == "repo" {
+				dst[key].Repo = repo
+			}
+		}
+		dst[key].Merge(stats, repo+"/")
+	}
+}
+
+// CollectStats analyzes the repositories returned by OpenRepositories and merges the results.
+// All repositories and revisions are resolved before any file is blamed.
+// The skipped files of all repositories are counted together.
+// onHunk, if not nil, receives every blamed hunk as files are parsed.
+func CollectStats(scan *scaner.Scaner, specs []RepoSpec, reporter progress.Reporter, onHunk func(BlameHunk) error) (map[string]*AuthorStats, SkippedFiles, error) {
+	labels := make(map[string]string)
+	skipped := make(SkippedFiles)
+	parsers := make([]*Parser, len(specs))
+	trees := make([][]TreeFile, len(specs))
+	for i, spec := range specs {
+		label := spec.Label()
+		if other, ok := labels[label]; ok {
+			return nil, nil, errs.InvalidFlag("repository", "repositories %s and %s have the same name %q; set distinct names in the manifest", other, spec.Path, label)
+		}
+		labels[label] = spec.Path
+
+		p := NewParser(spec.Scaner(scan))
+		p.Progress = reporter
+		p.Name = spec.Path
+		p.OnHunk = onHunk
+		if onHunk != nil && len(specs) > 1 {
+			p.OnHunk = func(hunk BlameHunk) error {
+				hunk.Repo = label
+				return onHunk(hunk)
+			}
+		}
+		files, err := p.LoadTree()
+		if err != nil {
+			return nil, nil, fmt.Errorf("repository %s: %w", spec.Path, err)
+		}
+		parsers[i], trees[i] = p, files
+		skipped.Add(p.Skipped)
+	}
+
+	if len(specs) == 1 && scan.By != "repo" {
+		if err := parsers[0].ParseFiles(trees[0]); err != nil {
+			return nil, nil, fmt.Errorf("repository %s: %w", specs[0].Path, err)
+		}
+		return groupStats(ApplyAliases(parsers[0].Stats, parsers[0].Scaner.Aliases), scan), skipped, nil
+	}
+	stats := make(map[string]*AuthorStats)
+	for i, p := range parsers {
+		if err := p.ParseFiles(trees[i]); err != nil {
+			return nil, nil, fmt.Errorf("repository %s: %w", specs[i].Path, err)
+		}
+		MergeStats(stats, ApplyAliases(p.Stats, p.Scaner.Aliases), specs[i].Label(), scan.By)
+	}
+	return groupStats(stats, scan), skipped, nil
+}
+
+// groupStats merges author rows into teams for --by=team.
+func groupStats(stats map[string]*AuthorStats, scan *scaner.Scaner) map[string]*AuthorStats {
+	if scan.By != "team" {
+		return stats
+	}
+	return GroupTeams(stats, scan.Teams)
+}
@@ -2,45 +2,158 @@ package parser
 
 import (
 	"bytes"
-	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/progress"
 	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"io"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 type AuthorStats struct {
-	Commits  map[string]bool
-	Files    map[string]bool
-	LinesCnt int
+	Name        string // display name when the map key is not the author name
+	Repo        string // repository label in --by=repo mode
+	Commits     IDSet  // hashes of the commits
+	Files       IDSet  // paths of the files
+	Emails      map[string]bool
+	LinesCnt    int
+	Ages        map[int]int // age of surviving lines in days -> number of lines
+	FirstCommit time.Time
+	LastCommit  time.Time
 }
 
 func NewAuthorStats() *AuthorStats {
 	return &AuthorStats{
-		Commits:  make(map[string]bool),
-		Files:    make(map[string]bool),
+		Emails:   make(map[string]bool),
 		LinesCnt: 0,
+		Ages:     make(map[int]int),
+	}
+}
+
+// AddCommitTime widens the [FirstCommit, LastCommit] range to include t.
+func (as *AuthorStats) AddCommitTime(t time.Time) {
+	if as.FirstCommit.IsZero() || t.Before(as.FirstCommit) {
+		as.FirstCommit = t
+	}
+	if t.After(as.LastCommit) {
+		as.LastCommit = t
+	}
+}
+
+// AddLineAge records lines written at t that survive at the analyzed revision made at reference.
+func (as *AuthorStats) AddLineAge(reference, t time.Time, lines int) {
+	days := int(reference.Sub(t).Hours() / 24)
+	if days < 0 {
+		days = 0
+	}
+	as.Ages[days] += lines
+}
+
+// Merge adds other to as; filePrefix is prepended to the file paths of other.
+func (as *AuthorStats) Merge(other *AuthorStats, filePrefix string) {
+	as.Commits.Union(&other.Commits, "")
+	as.Files.Union(&other.Files, filePrefix)
+	for email := range other.Emails {
+		as.Emails[email] = true
+	}
+	as.LinesCnt += other.LinesCnt
+	for days, lines := range other.Ages {
+		as.Ages[days] += lines
+	}
+	if !other.FirstCommit.IsZero() {
+		as.AddCommitTime(other.FirstCommit)
+		as.AddCommitTime(other.LastCommit)
 	}
 }
 
 type Parser struct {
 	Scaner *scaner.Scaner
 	Stats  map[string]*AuthorStats // key - author name, value - author stats
+	// BlameSlots, if set, bounds the number of git blame processes shared between parsers.
+	BlameSlots chan struct{}
+	Progress   progress.Reporter     // may be nil
+	Name       string                // repository name shown in progress, defaults to Scaner.Repository
+	Skipped    SkippedFiles          // files left out by LoadTree
+	Reference  time.Time             // commit time of the analyzed revision, line ages are relative to it
+	FileLines  FileOwnership         // lines of each author per file; collected only if not nil
+	OnHunk     func(BlameHunk) error // receives every blamed hunk if set
+
+	uncommittedAuthor string // author of uncommitted lines with --worktree
+	uncommittedEmail  string
+	commitIDs         *Interner // shared by the commit sets of Stats
+	pathIDs           *Interner // shared by the file sets of Stats
 }
 
 func NewParser(scan *scaner.Scaner) *Parser {
 	return &Parser{
-		Scaner: scan,
-		Stats:  make(map[string]*AuthorStats),
+		Scaner:  scan,
+		Stats:   make(map[string]*AuthorStats),
+		Skipped: make(SkippedFiles),
+
+		commitIDs: NewInterner(),
+		pathIDs:   NewInterner(),
 	}
 }
 
+// author returns the stats of the author, adding them on first use.
+func (p *Parser) author(name string) *AuthorStats {
+	as, ok := p.Stats[name]
+	if !ok {
+		as = NewAuthorStats()
+		as.Commits = NewIDSet(p.commitIDs)
+		as.Files = NewIDSet(p.pathIDs)
+		p.Stats[name] = as
+	}
+	return as
+}
+
 func CreateCmd(name string, args ...string) (string, error) {
-	var stdout, stderr bytes.Buffer
+	return CreateCmdInDir("", name, args...)
+}
+
+// CreateCmdInDir runs the command in dir; an empty dir means the current directory.
+func CreateCmdInDir(dir string, name string, args ...string) (string, error) {
 	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	return RunCmd(cmd)
+}
+
+// StreamCmd runs a prepared command passing its stdout to read while it is
+// produced. Errors of read are returned as is and stop the command; the rest
+// of the output is discarded if read returns early without an error.
+func StreamCmd(cmd *exec.Cmd, read func(io.Reader) error) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return &errs.GitError{Args: cmd.Args, Dir: cmd.Dir, Stderr: stderr.String(), Err: err}
+	}
+	if err := read(stdout); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	_, err = io.Copy(io.Discard, stdout)
+	if waitErr := cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return &errs.GitError{Args: cmd.Args, Dir: cmd.Dir, Stderr: stderr.String(), Err: err}
+	}
+	return nil
+}
+
+// RunCmd runs a prepared command and returns its trimmed stdout.
+func RunCmd(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf(stderr.String())
+		return "", &errs.GitError{Args: cmd.Args, Dir: cmd.Dir, Stderr: stderr.String(), Err: err}
 	}
 	return strings.TrimSpace(stdout.String()), nil
 }
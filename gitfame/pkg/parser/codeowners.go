@@ -0,0 +1,350 @@
+package parser
+
+import (
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/progress"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FileOwnership is the number of lines of each author per file path.
+type FileOwnership map[string]map[string]int
+
+// Rename merges the lines of authors with the same name in names.
+func (o FileOwnership) Rename(names map[string]string) FileOwnership {
+	renamed := make(FileOwnership, len(o))
+	for file, lines := range o {
+		renamed[file] = make(map[string]int, len(lines))
+		for author, n := range lines {
+			name, ok := names[author]
+			if !ok {
+				name = author
+			}
+			renamed[file][name] += n
+		}
+	}
+	return renamed
+}
+
+// OwnersRule is a line of a CODEOWNERS file.
+type OwnersRule struct {
+	Pattern string
+	Owners  []string
+	Line    int // line number in the parsed file, 0 for generated rules
+
+	re *regexp.Regexp
+}
+
+// CompileOwnersPattern converts a CODEOWNERS pattern to a regexp matching file
+// paths. As in gitignore, a pattern with a leading or inner slash is relative
+// to the root, other patterns match at any depth, a pattern matching a
+// directory matches all files below it and a trailing slash matches only
+// directories. "*" and "?" don't match slashes, "**" matches across them.
+func CompileOwnersPattern(pattern string) (*regexp.Regexp, error) {
+	body := strings.TrimSuffix(pattern, "/")
+	dirOnly := body != pattern
+	anchored := strings.Contains(body, "/")
+	body = strings.TrimPrefix(body, "/")
+	if body == "" {
+		return nil, fmt.Errorf("empty pattern %q", pattern)
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(body); i++ {
+		switch {
+		case strings.HasPrefix(body[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(body[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case body[i] == '*':
+			re.WriteString("[^/]*")
+		case body[i] == '?':
+			re.WriteString("[^/]")
+		case body[i] == '\\' && i+1 < len(body):
+			i++
+			re.WriteString(regexp.QuoteMeta(body[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(body[i : i+1]))
+		}
+	}
+	if dirOnly {
+		re.WriteString("/.*$")
+	} else {
+		re.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(re.String())
+}
+
+// Match reports whether the rule applies to the file path.
+func (r OwnersRule) Match(file string) bool {
+	return r.re != nil && r.re.MatchString(file)
+}
+
+// NewOwnersRule compiles a rule for pattern.
+func NewOwnersRule(pattern string, owners []string) (OwnersRule, error) {
+	re, err := CompileOwnersPattern(pattern)
+	if err != nil {
+		return OwnersRule{}, err
+	}
+	return OwnersRule{Pattern: pattern, Owners: owners, re: re}, nil
+}
+
+// MatchOwnersRule returns the index of the last rule matching file, as the
+// last matching line of a CODEOWNERS file takes precedence, or -1.
+func MatchOwnersRule(rules []OwnersRule, file string) int {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].Match(file) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CodeownersOptions select the owners proposed for each rule.
+type CodeownersOptions struct {
+	Share     float64           // share of lines the owners must cover
+	MinOwners int               // minimum number of owners if there are enough authors
+	Handles   map[string]string // lowercased name or email -> handle
+	Departed  map[string]bool   // lowercased names and emails never proposed
+	Depth     int               // directory depth of rules without Paths
+	Paths     []string          // patterns to generate rules for instead of directories
+}
+
+// NewCodeownersOptions reads the codeowners flags of scan.
+func NewCodeownersOptions(scan *scaner.Scaner) (*CodeownersOptions, error) {
+	if scan.Share <= 0 || scan.Share > 1 {
+		return nil, errs.InvalidFlag("share", "must be in (0, 1], got %v", scan.Share)
+	}
+	if scan.MinOwners < 0 {
+		return nil, errs.InvalidFlag("min-owners", "must not be negative")
+	}
+	if scan.Depth < 0 {
+		return nil, errs.InvalidFlag("depth", "must not be negative")
+	}
+	opts := &CodeownersOptions{
+		Share:     scan.Share,
+		MinOwners: scan.MinOwners,
+		Departed:  make(map[string]bool),
+		Depth:     scan.Depth,
+	}
+	for _, pattern := range SplitByDot(scan.Paths) {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := CompileOwnersPattern(pattern); err != nil {
+			return nil, errs.InvalidFlag("paths", "%w", err)
+		}
+		opts.Paths = append(opts.Paths, pattern)
+	}
+	for _, identity := range SplitByDot(scan.Departed) {
+		opts.Departed[strings.ToLower(strings.TrimSpace(identity))] = true
+	}
+	handles, err := LoadHandles(scan.Handles)
+	if err != nil {
+		return nil, err
+	}
+	opts.Handles = handles
+	return opts, nil
+}
+
+// LoadHandles reads the --handles file; the keys are lowercased.
+// An empty path means no handles.
+func LoadHandles(path string) (map[string]string, error) {
+	handles := make(map[string]string)
+	if path == "" {
+		return handles, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errs.InvalidFlag("handles", "%w", err)
+	}
+	var file map[string]string
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errs.InvalidFlag("handles", "%s: %w", path, err)
+	}
+	for identity, handle := range file {
+		handles[strings.ToLower(identity)] = handle
+	}
+	return handles, nil
+}
+
+// CollectOwnership blames the repository of spec and returns the lines of
+// each author per file together with the author stats, with aliases applied.
+func CollectOwnership(scan *scaner.Scaner, spec RepoSpec, reporter progress.Reporter) (FileOwnership, map[string]*AuthorStats, error) {
+	p := NewParser(spec.Scaner(scan))
+	p.Progress = reporter
+	p.Name = spec.Path
+	p.FileLines = make(FileOwnership)
+	if err := p.DoRoutine(); err != nil {
+		return nil, nil, fmt.Errorf("repository %s: %w", spec.Path, err)
+	}
+	names := aliasNames(p.Stats, p.Scaner.Aliases)
+	return p.FileLines.Rename(names), ApplyAliases(p.Stats, p.Scaner.Aliases), nil
+}
+
+// identities returns the lowercased name and emails of an author.
+func identities(author string, stats map[string]*AuthorStats) []string {
+	ids := []string{strings.ToLower(author)}
+	if as, ok := stats[author]; ok {
+		for _, email := range sortedEmails(as) {
+			ids = append(ids, strings.ToLower(email))
+		}
+	}
+	return ids
+}
+
+func (o *CodeownersOptions) departed(author string, stats map[string]*AuthorStats) bool {
+	for _, id := range identities(author, stats) {
+		if o.Departed[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// handle returns the handle of an author, falling back to the email.
+func (o *CodeownersOptions) handle(author string, stats map[string]*AuthorStats) string {
+	for _, id := range identities(author, stats) {
+		if handle, ok := o.Handles[id]; ok {
+			return handle
+		}
+	}
+	if as, ok := stats[author]; ok && len(as.Emails) > 0 {
+		return sortedEmails(as)[0]
+	}
+	return author
+}
+
+// ruleGroups assigns files to the patterns of generated rules: to the last
+// matching pattern of o.Paths, or to their directory cut at o.Depth.
+func (o *CodeownersOptions) ruleGroups(files FileOwnership) ([]string, map[string]map[string]int, error) {
+	groups := make(map[string]map[string]int)
+	add := func(pattern string, lines map[string]int) {
+		if _, ok := groups[pattern]; !ok {
+			groups[pattern] = make(map[string]int)
+		}
+		for author, n := range lines {
+			groups[pattern][author] += n
+		}
+	}
+	if len(o.Paths) > 0 {
+		rules := make([]OwnersRule, len(o.Paths))
+		for i, pattern := range o.Paths {
+			rule, err := NewOwnersRule(pattern, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			rules[i] = rule
+		}
+		for file, lines := range files {
+			if i := MatchOwnersRule(rules, file); i >= 0 {
+				add(rules[i].Pattern, lines)
+			}
+		}
+		var patterns []string
+		for _, pattern := range o.Paths {
+			if _, ok := groups[pattern]; ok {
+				patterns = append(patterns, pattern)
+			}
+		}
+		return patterns, groups, nil
+	}
+	for file, lines := range files {
+		dir := path.Dir(file)
+		parts := strings.Split(dir, "/")
+		if dir == "." || o.Depth == 0 {
+			parts = nil
+		} else if len(parts) > o.Depth {
+			parts = parts[:o.Depth]
+		}
+		pattern := "*"
+		if len(parts) > 0 {
+			pattern = "/" + strings.ReplaceAll(strings.Join(parts, "/"), " ", `\ `) + "/"
+		}
+		add(pattern, lines)
+	}
+	patterns := make([]string, 0, len(groups))
+	for pattern := range groups {
+		patterns = append(patterns, pattern)
+	}
+	// "*" sorts before "/", so the catch-all rule comes first and deeper
+	// directories follow their parents, as later rules take precedence
+	sort.Strings(patterns)
+	return patterns, groups, nil
+}
+
+// selectOwners returns the authors with most lines until they cover o.Share
+// of the lines and there are at least o.MinOwners of them. Departed authors
+// are not counted.
+func (o *CodeownersOptions) selectOwners(lines map[string]int, stats map[string]*AuthorStats) []string {
+	var authors []string
+	total := 0
+	for author, n := range lines {
+		if n > 0 && !o.departed(author, stats) {
+			authors = append(authors, author)
+			total += n
+		}
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if lines[authors[i]] != lines[authors[j]] {
+			return lines[authors[i]] > lines[authors[j]]
+		}
+		return authors[i] < authors[j]
+	})
+	var owners []string
+	seen := make(map[string]bool)
+	covered := 0
+	for _, author := range authors {
+		if float64(covered) >= o.Share*float64(total) && len(owners) >= o.MinOwners {
+			break
+		}
+		covered += lines[author]
+		if handle := o.handle(author, stats); !seen[handle] {
+			seen[handle] = true
+			owners = append(owners, handle)
+		}
+	}
+	return owners
+}
+
+// GenerateCodeowners proposes a CODEOWNERS rule for each directory or pattern.
+// A rule whose authors all departed has no owners.
+func GenerateCodeowners(files FileOwnership, stats map[string]*AuthorStats, opts *CodeownersOptions) ([]OwnersRule, error) {
+	patterns, groups, err := opts.ruleGroups(files)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]OwnersRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		rule, err := NewOwnersRule(pattern, opts.selectOwners(groups[pattern], stats))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// WriteCodeowners writes rules in the CODEOWNERS format after a header comment.
+func WriteCodeowners(w io.Writer, header string, rules []OwnersRule) error {
+	if _, err := fmt.Fprintf(w, "# %s\n", header); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		line := strings.Join(append([]string{rule.Pattern}, rule.Owners...), " ")
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
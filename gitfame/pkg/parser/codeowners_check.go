@@ -0,0 +1,218 @@
+package parser
+
+import (
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CodeownersFiles are the locations of CODEOWNERS in the order GitHub looks them up.
+var CodeownersFiles = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// Kinds of OwnershipDrift.
+const (
+	DriftUnowned  = "unowned" // no rule matches the path or the rule has no owners
+	DriftNoOwners = "drift"   // no declared owner wrote lines of the path
+)
+
+// OwnershipDrift is a path whose blame ownership doesn't match CODEOWNERS.
+type OwnershipDrift struct {
+	Kind    string
+	Path    string
+	Rule    *OwnersRule // matching rule, nil if there is none
+	Authors []string    // handles of the authors by lines
+}
+
+// splitOwnersLine splits a CODEOWNERS line into fields at unescaped
+// whitespace, dropping comments.
+func splitOwnersLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			field.WriteByte(c)
+			field.WriteByte(line[i+1])
+			i++
+		case c == ' ' || c == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		case c == '#' && field.Len() == 0:
+			return fields
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// ParseCodeowners parses the rules of a CODEOWNERS file named name.
+func ParseCodeowners(name, data string) ([]OwnersRule, error) {
+	var rules []OwnersRule
+	for i, line := range strings.Split(data, "\n") {
+		fields := splitOwnersLine(strings.TrimRight(line, "\r"))
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := NewOwnersRule(fields[0], fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, i+1, err)
+		}
+		rule.Line = i + 1
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// LoadCodeowners reads --codeowners, or the CODEOWNERS file of scan.Repository
+// at scan.Revision, and returns its name and rules.
+func LoadCodeowners(scan *scaner.Scaner) (string, []OwnersRule, error) {
+	if scan.Codeowners != "" {
+		data, err := os.ReadFile(scan.Codeowners)
+		if err != nil {
+			return "", nil, errs.InvalidFlag("codeowners", "%w", err)
+		}
+		rules, err := ParseCodeowners(scan.Codeowners, string(data))
+		if err != nil {
+			return "", nil, errs.InvalidFlag("codeowners", "%w", err)
+		}
+		return scan.Codeowners, rules, nil
+	}
+	repo, err := ResolveRepository(scan.Repository)
+	if err != nil {
+		return "", nil, err
+	}
+	revision, err := repo.ResolveRevision(scan.Revision)
+	if err != nil {
+		return "", nil, &errs.UnknownRevisionError{Repository: scan.Repository, Revision: scan.Revision}
+	}
+	for _, name := range CodeownersFiles {
+		object := revision + ":" + name
+		if _, err := repo.Git("cat-file", "-e", object); err != nil {
+			continue
+		}
+		data, err := repo.Git("cat-file", "blob", object)
+		if err != nil {
+			return "", nil, err
+		}
+		rules, err := ParseCodeowners(name, data)
+		if err != nil {
+			return "", nil, err
+		}
+		return name, rules, nil
+	}
+	return "", nil, errs.InvalidFlag("codeowners", "no CODEOWNERS file at %s, looked in %s",
+		scan.Revision, strings.Join(CodeownersFiles, ", "))
+}
+
+// ownsLines reports whether the owner wrote lines as author: owners are
+// matched with the name and emails of the author, their handles and the
+// configured teams.
+func ownsLines(owner, author string, stats map[string]*AuthorStats, handles map[string]string, teams map[string][]string) bool {
+	owner = strings.ToLower(owner)
+	ids := identities(author, stats)
+	for _, id := range ids {
+		if id == owner || strings.ToLower(handles[id]) == owner {
+			return true
+		}
+	}
+	// @org/team owners match the team of the same name
+	_, ownerTeam, _ := strings.Cut(strings.TrimPrefix(owner, "@"), "/")
+	for team, members := range teams {
+		team = strings.ToLower(team)
+		if team != owner && "@"+team != owner && team != ownerTeam {
+			continue
+		}
+		for _, member := range members {
+			for _, id := range ids {
+				if isMember(member, id) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// CheckCodeowners compares rules with the blame ownership of files and
+// returns the drifted paths sorted by path.
+func CheckCodeowners(files FileOwnership, stats map[string]*AuthorStats, rules []OwnersRule, handles map[string]string, teams map[string][]string) []OwnershipDrift {
+	paths := make([]string, 0, len(files))
+	for file := range files {
+		paths = append(paths, file)
+	}
+	sort.Strings(paths)
+	opts := &CodeownersOptions{Handles: handles}
+
+	var drift []OwnershipDrift
+	for _, file := range paths {
+		lines := files[file]
+		authors := make([]string, 0, len(lines))
+		for author := range lines {
+			authors = append(authors, author)
+		}
+		sort.Slice(authors, func(i, j int) bool {
+			if lines[authors[i]] != lines[authors[j]] {
+				return lines[authors[i]] > lines[authors[j]]
+			}
+			return authors[i] < authors[j]
+		})
+		d := OwnershipDrift{Kind: DriftUnowned, Path: file}
+		for _, author := range authors {
+			d.Authors = append(d.Authors, opts.handle(author, stats))
+		}
+		i := MatchOwnersRule(rules, file)
+		if i >= 0 {
+			d.Rule = &rules[i]
+		}
+		if i < 0 || len(rules[i].Owners) == 0 {
+			drift = append(drift, d)
+			continue
+		}
+		owned := false
+		for _, owner := range rules[i].Owners {
+			for _, author := range authors {
+				owned = owned || ownsLines(owner, author, stats, handles, teams)
+			}
+		}
+		if !owned {
+			d.Kind = DriftNoOwners
+			drift = append(drift, d)
+		}
+	}
+	return drift
+}
+
+// WriteDrift writes the drifted paths after a header comment and a summary of total checked files.
+func WriteDrift(w io.Writer, header string, drift []OwnershipDrift, total int) error {
+	if _, err := fmt.Fprintf(w, "# %s\n", header); err != nil {
+		return err
+	}
+	for _, d := range drift {
+		line := d.Kind + " " + d.Path
+		if d.Rule != nil {
+			line += fmt.Sprintf(": rule %q (line %d)", d.Rule.Pattern, d.Rule.Line)
+			if len(d.Rule.Owners) > 0 {
+				line += " owners " + strings.Join(d.Rule.Owners, ", ")
+			}
+			line += ";"
+		} else {
+			line += ": no rule;"
+		}
+		line += " authors " + strings.Join(d.Authors, ", ")
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "# %d of %d files drifted\n", len(drift), total)
+	return err
+}
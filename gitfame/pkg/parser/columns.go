@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column is a metric that can be selected with --columns.
+// Registering a column in columnRegistry makes it available in every formatter.
+type Column struct {
+	Key    string // name used in --columns and as a json key
+	Header string // header used by tabular, csv, markdown and html output
+	Total  bool   // whether the column is part of the json totals object
+	Value  func(row StatsShare) any
+}
+
+var columnRegistry = []Column{
+	{"name", "Name", false, func(r StatsShare) any { return r.Name }},
+	{"repo", "Repo", false, func(r StatsShare) any { return r.Repo }},
+	{"email", "Email", false, func(r StatsShare) any { return r.Email }},
+	{"lines", "Lines", true, func(r StatsShare) any { return r.Lines }},
+	{"commits", "Commits", true, func(r StatsShare) any { return r.Commits }},
+	{"files", "Files", true, func(r StatsShare) any { return r.Files }},
+	{"lines%", "Lines%", false, func(r StatsShare) any { return r.LinesShare }},
+	{"commits%", "Commits%", false, func(r StatsShare) any { return r.CommitsShare }},
+	{"files%", "Files%", false, func(r StatsShare) any { return r.FilesShare }},
+	{"median_age", "MedianAge", true, func(r StatsShare) any { return medianAge(r.Ages) }},
+	{"first_commit", "FirstCommit", true, func(r StatsShare) any { return r.FirstCommit }},
+	{"last_commit", "LastCommit", true, func(r StatsShare) any { return r.LastCommit }},
+}
+
+var (
+	defaultColumns    = []string{"name", "lines", "commits", "files"}
+	percentageColumns = []string{"lines%", "commits%", "files%"}
+)
+
+func LookupColumn(key string) (Column, bool) {
+	for _, col := range columnRegistry {
+		if col.Key == key {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// lookupColumn resolves key among the registered columns and the columns
+// of the --age-buckets buckets.
+func lookupColumn(key string, ageColumns []Column) (Column, bool) {
+	if col, ok := LookupColumn(key); ok {
+		return col, true
+	}
+	idx := slices.IndexFunc(ageColumns, func(c Column) bool { return c.Key == key })
+	if idx < 0 {
+		return Column{}, false
+	}
+	return ageColumns[idx], true
+}
+
+// ParseColumns resolves a comma separated list of column keys.
+// An empty list selects the default columns, with the repo column after the name for --by=repo;
+// percentages appends the share columns and ages appends the line age columns.
+// ageColumns are the columns of the --age-buckets buckets.
+func ParseColumns(list string, percentages, ages bool, by string, ageColumns []Column) ([]Column, error) {
+	keys := SplitByDot(list)
+	if len(keys) == 0 {
+		keys = defaultColumns
+		if by == "repo" {
+			keys = append([]string{keys[0], by}, keys[1:]...)
+		}
+	}
+	if percentages {
+		keys = append(keys[:len(keys):len(keys)], percentageColumns...)
+	}
+	if ages {
+		keys = keys[:len(keys):len(keys)]
+		for _, col := range ageColumns {
+			keys = append(keys, col.Key)
+		}
+		keys = append(keys, "median_age")
+	}
+	seen := make(map[string]bool)
+	var columns []Column
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		col, ok := lookupColumn(key, ageColumns)
+		if !ok {
+			return nil, errs.InvalidFlag("columns", "unknown column %q", key)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// Text formats the column value for the text based formatters.
+func (c Column) Text(row StatsShare) string {
+	return formatValue(c.Value(row))
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return formatShare(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.DateOnly)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func formatShare(f float64) string {
+	return strconv.FormatFloat(f, 'f', 1, 64)
+}
+
+// IsNumeric reports whether the column holds numbers.
+func (c Column) IsNumeric() bool {
+	switch c.Value(StatsShare{}).(type) {
+	case int, float64:
+		return true
+	}
+	return false
+}
+
+// jsonObject is a json object that keeps the order of the selected columns.
+type jsonObject struct {
+	keys   []string
+	values []any
+}
+
+func newJSONObject(columns []Column, row StatsShare) jsonObject {
+	var obj jsonObject
+	for _, col := range columns {
+		v := col.Value(row)
+		if _, ok := v.(time.Time); ok {
+			v = formatValue(v)
+		}
+		obj.keys = append(obj.keys, col.Key)
+		obj.values = append(obj.values, v)
+	}
+	return obj
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
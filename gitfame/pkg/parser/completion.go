@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"github.com/spf13/cobra"
+	"gitlab.com/slon/shad-go/gitfame/configs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"strings"
+)
+
+// Completions returns shell completion functions of the report flags.
+func Completions() map[string]scaner.CompletionFunc {
+	return map[string]scaner.CompletionFunc{
+		"format":    completeFormat,
+		"order-by":  completeOrderBy,
+		"languages": completeLanguages,
+		"revision":  completeRevision,
+	}
+}
+
+func completeFormat(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return filterPrefix(Formats, "", toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// splitLast splits a comma separated list into the completed part and the last item.
+func splitLast(list string) (string, string) {
+	i := strings.LastIndex(list, ",")
+	return list[:i+1], list[i+1:]
+}
+
+func filterPrefix(values []string, prefix, toComplete string) []string {
+	var matches []string
+	for _, value := range values {
+		if strings.HasPrefix(strings.ToLower(value), strings.ToLower(toComplete)) {
+			matches = append(matches, prefix+value)
+		}
+	}
+	return matches
+}
+
+func completeOrderBy(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	prefix, last := splitLast(toComplete)
+	if strings.HasPrefix(last, "-") || strings.HasPrefix(last, "+") {
+		prefix, last = prefix+last[:1], last[1:]
+	}
+	keys := make([]string, 0, len(columnRegistry))
+	for _, col := range columnRegistry {
+		keys = append(keys, col.Key)
+	}
+	buckets, _ := cmd.Flags().GetString("age-buckets")
+	if ageColumns, err := AgeColumns(buckets); err == nil {
+		for _, col := range ageColumns {
+			keys = append(keys, col.Key)
+		}
+	}
+	return filterPrefix(keys, prefix, last), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+func completeLanguages(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	langs, err := configs.ParseLangs()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	names := make([]string, 0, len(langs))
+	for _, lang := range langs {
+		names = append(names, strings.ToLower(lang.Name))
+	}
+	prefix, last := splitLast(toComplete)
+	return filterPrefix(names, prefix, last), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// completeRevision lists branches and tags of the first --repository.
+func completeRevision(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	repository := "."
+	if repos, _ := cmd.Flags().GetStringArray("repository"); len(repos) > 0 {
+		repository = repos[0]
+	}
+	repo, err := ResolveRepository(repository)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	out, err := repo.Git("for-each-ref", "--format=%(refname:short)", "refs/heads", "refs/tags", "refs/remotes")
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	refs := append([]string{"HEAD"}, strings.Fields(out)...)
+	return filterPrefix(refs, "", toComplete), cobra.ShellCompDirectiveNoFileComp
+}
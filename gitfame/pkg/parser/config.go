@@ -0,0 +1,224 @@
+package parser
+
+import (
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"gopkg.in/yaml.v2"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ConfigFile is read from the repository root at the analyzed revision.
+const ConfigFile = ".gitfame.yml"
+
+// configOnlyFlags select the repository and the configuration itself,
+// so they can't be set in a configuration file.
+var configOnlyFlags = []string{"repository", "manifest", "revision", "config", "help"}
+
+// Config holds defaults shared by every run on a repository.
+type Config struct {
+	Flags      map[string]any      `yaml:"flags"`       // flag name -> default value
+	Exclude    []string            `yaml:"exclude"`     // default --exclude patterns
+	RestrictTo []string            `yaml:"restrict-to"` // default --restrict-to patterns
+	Aliases    map[string][]string `yaml:"aliases"`     // canonical name -> names and emails
+	Teams      map[string][]string `yaml:"teams"`       // team -> globs of names and emails of members
+}
+
+// ParseConfig parses the configuration read from the file name.
+func ParseConfig(name string, data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, errs.InvalidFlag("config", "%s: %w", name, err)
+	}
+	if err := validateTeams("config", name, config.Teams); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// LoadConfig reads --config, or .gitfame.yml of scan.Repository at scan.Revision.
+// A repository without the file has an empty configuration.
+func LoadConfig(scan *scaner.Scaner) (*Config, error) {
+	if scan.Config != "" {
+		data, err := os.ReadFile(scan.Config)
+		if err != nil {
+			return nil, errs.InvalidFlag("config", "%w", err)
+		}
+		return ParseConfig(scan.Config, data)
+	}
+	repo, err := ResolveRepository(scan.Repository)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := repo.ResolveRevision(scan.Revision)
+	if err != nil {
+		return nil, &errs.UnknownRevisionError{Repository: scan.Repository, Revision: scan.Revision}
+	}
+	object := revision + ":" + ConfigFile
+	if _, err := repo.Git("cat-file", "-e", object); err != nil {
+		return &Config{}, nil
+	}
+	data, err := repo.Git("cat-file", "blob", object)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(ConfigFile, []byte(data))
+}
+
+func configValue(name string, value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool, int, float64:
+		return fmt.Sprint(value), nil
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			text, err := configValue(name, item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", errs.InvalidFlag("config", "flag %q: unsupported value %v", name, value)
+}
+
+// SetsFlags reports whether Apply may change flags, which then have to be
+// validated again.
+func (c *Config) SetsFlags() bool {
+	return len(c.Flags) > 0 || len(c.Exclude) > 0 || len(c.RestrictTo) > 0
+}
+
+// ApplyConfigs applies --config, or the .gitfame.yml of the analyzed
+// repository, to scan. With several repositories and no --config each
+// .gitfame.yml only applies to its own repository: exclude, restrict-to and
+// aliases are set on the spec, while flags and teams, which would change the
+// whole run, are rejected. It reports whether flags of scan may have changed.
+func ApplyConfigs(scan *scaner.Scaner, specs []RepoSpec) (bool, error) {
+	if scan.Config != "" || len(specs) == 1 {
+		config, err := LoadConfig(specs[0].Scaner(scan))
+		if err != nil {
+			return false, err
+		}
+		return config.SetsFlags(), config.Apply(scan)
+	}
+	for i := range specs {
+		spec := &specs[i]
+		config, err := LoadConfig(spec.Scaner(scan))
+		if err != nil {
+			return false, fmt.Errorf("repository %s: %w", spec.Path, err)
+		}
+		if len(config.Flags) > 0 || len(config.Teams) > 0 {
+			return false, errs.InvalidFlag("config", "%s of repository %s: flags and teams apply to every repository, set them with --config", ConfigFile, spec.Path)
+		}
+		if spec.Exclude == "" && scan.Exclude == "" {
+			spec.Exclude = strings.Join(config.Exclude, ",")
+		}
+		if spec.RestrictTo == "" && scan.RestrictTo == "" {
+			spec.RestrictTo = strings.Join(config.RestrictTo, ",")
+		}
+		spec.Aliases = config.Aliases
+	}
+	// reads --teams
+	return false, (&Config{}).Apply(scan)
+}
+
+// Apply sets the flags of scan that were not given on the command line.
+func (c *Config) Apply(scan *scaner.Scaner) error {
+	values := make(map[string]string)
+	for name, value := range c.Flags {
+		if slices.Contains(configOnlyFlags, name) {
+			return errs.InvalidFlag("config", "flag %q can't be set in a configuration file", name)
+		}
+		text, err := configValue(name, value)
+		if err != nil {
+			return err
+		}
+		values[name] = text
+	}
+	patterns := map[string][]string{"exclude": c.Exclude, "restrict-to": c.RestrictTo}
+	for name, list := range patterns {
+		if len(list) == 0 {
+			continue
+		}
+		if _, ok := values[name]; ok {
+			return errs.InvalidFlag("config", "%q is set both in flags and at the top level", name)
+		}
+		values[name] = strings.Join(list, ",")
+	}
+	scan.Aliases = c.Aliases
+	scan.Teams = c.Teams
+	if err := scan.SetDefaults(values); err != nil {
+		return err
+	}
+	if scan.TeamsFile != "" {
+		teams, err := LoadTeams(scan.TeamsFile)
+		if err != nil {
+			return err
+		}
+		scan.Teams = teams
+	}
+	if scan.By == "team" && len(scan.Teams) == 0 {
+		return errs.InvalidFlag("by", "--by=team needs --teams or teams in the configuration")
+	}
+	return nil
+}
+
+// aliasNames maps every author of stats to its canonical name in aliases,
+// matched by name or email.
+func aliasNames(stats map[string]*AuthorStats, aliases map[string][]string) map[string]string {
+	canonical := make(map[string]string)
+	for name, identities := range aliases {
+		canonical[strings.ToLower(name)] = name
+		for _, identity := range identities {
+			canonical[strings.ToLower(identity)] = name
+		}
+	}
+	names := make(map[string]string)
+	for author, as := range stats {
+		names[author] = author
+		if name, ok := canonical[strings.ToLower(author)]; ok {
+			names[author] = name
+			continue
+		}
+		for _, email := range sortedEmails(as) {
+			if name, ok := canonical[strings.ToLower(email)]; ok {
+				names[author] = name
+				break
+			}
+		}
+	}
+	return names
+}
+
+func sortedEmails(as *AuthorStats) []string {
+	emails := make([]string, 0, len(as.Emails))
+	for email := range as.Emails {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// ApplyAliases merges the stats of authors listed in aliases, matched by
+// name or email, into their canonical names.
+func ApplyAliases(stats map[string]*AuthorStats, aliases map[string][]string) map[string]*AuthorStats {
+	if len(aliases) == 0 {
+		return stats
+	}
+	merged := make(map[string]*AuthorStats)
+	for author, name := range aliasNames(stats, aliases) {
+		if _, ok := merged[name]; !ok {
+			merged[name] = NewAuthorStats()
+		}
+		merged[name].Merge(stats[author], "")
+	}
+	return merged
+}
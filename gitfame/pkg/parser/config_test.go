@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+)
+
+func TestApplyConfigsPerRepository(t *testing.T) {
+	first := gittest.New(t)
+	first.Commit("Alice", map[string]string{"a.go": "package a\n", "gen.go": "package a\n\nvar X = 1\n"})
+	first.Commit("Carol", map[string]string{ConfigFile: "exclude: [gen.go]\naliases:\n  Core: [Alice]\n"})
+	second := gittest.New(t)
+	second.Commit("Alice", map[string]string{"gen.go": "package b\n"})
+	second.Commit("Bob", map[string]string{"b.go": "package b\n\nvar Y = 2\n"})
+
+	// the excludes and aliases of the first repository don't apply to the second
+	lines := collect(t, scan(t, "--repository", first.Dir, "--repository", second.Dir, "--extensions", ".go"))
+	require.Equal(t, map[string]int{"Core": 1, "Alice": 1, "Bob": 3}, lines)
+
+	// command line flags take precedence over the configurations
+	lines = collect(t, scan(t, "--repository", first.Dir, "--repository", second.Dir, "--extensions", ".go", "--exclude", "b.go"))
+	require.Equal(t, map[string]int{"Core": 4, "Alice": 1}, lines)
+
+	// flags would apply to every repository
+	second.Commit("Bob", map[string]string{ConfigFile: "flags:\n  order-by: files\n"})
+	s := scan(t, "--repository", first.Dir, "--repository", second.Dir)
+	specs, cleanup, err := OpenRepositories(s)
+	defer cleanup()
+	require.NoError(t, err)
+	_, err = ApplyConfigs(s, specs)
+	require.ErrorContains(t, err, "set them with --config")
+	require.Equal(t, errs.ExitInvalidFlags, errs.ExitCode(err))
+}
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"bytes"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxMissingShown bounds the number of missing paths listed in an error.
+const maxMissingShown = 10
+
+// ReadFilesFrom reads the paths listed in --files-from into scan.Files; "-"
+// reads them from in. Paths are separated by newlines, or by null bytes if
+// the list has any, and are relative to the repository root.
+func ReadFilesFrom(scan *scaner.Scaner, in io.Reader) error {
+	if scan.FilesFrom == "" {
+		return nil
+	}
+	var data []byte
+	var err error
+	if scan.FilesFrom == "-" {
+		data, err = io.ReadAll(in)
+	} else {
+		data, err = os.ReadFile(scan.FilesFrom)
+	}
+	if err != nil {
+		return errs.InvalidFlag("files-from", "%w", err)
+	}
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	seen := make(map[string]bool)
+	scan.Files = nil
+	for _, path := range strings.Split(string(data), sep) {
+		path = strings.TrimPrefix(strings.TrimRight(path, "\r"), "./")
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		scan.Files = append(scan.Files, path)
+	}
+	return nil
+}
+
+// selectFiles keeps the files listed in paths and fails if any of them is not
+// a file of the tree.
+func selectFiles(files []TreeFile, paths []string, revision string) ([]TreeFile, error) {
+	byPath := make(map[string]TreeFile, len(files))
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+	selected := make([]TreeFile, 0, len(paths))
+	var missing []string
+	for _, path := range paths {
+		file, ok := byPath[path]
+		if !ok {
+			missing = append(missing, path)
+			continue
+		}
+		selected = append(selected, file)
+	}
+	if len(missing) > 0 {
+		shown := missing
+		if len(shown) > maxMissingShown {
+			shown = shown[:maxMissingShown]
+		}
+		list := strings.Join(shown, ", ")
+		if len(missing) > len(shown) {
+			list += ", ..."
+		}
+		return nil, errs.InvalidFlag("files-from", "%d of %d paths are not files at %s: %s", len(missing), len(paths), revision, list)
+	}
+	return selected, nil
+}
@@ -2,9 +2,33 @@ package parser
 
 import (
 	"gitlab.com/slon/shad-go/gitfame/configs"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
+var languageByExt = sync.OnceValue(func() map[string]string {
+	byExt := make(map[string]string)
+	allLang, err := configs.ParseLangs()
+	if err != nil {
+		return byExt
+	}
+	for _, lang := range allLang {
+		for _, ext := range lang.Extensions {
+			// the first language listing an extension wins
+			if _, ok := byExt[ext]; !ok {
+				byExt[ext] = lang.Name
+			}
+		}
+	}
+	return byExt
+})
+
+// LanguageOf returns the language of a file by its extension, or "" if unknown.
+func LanguageOf(path string) string {
+	return languageByExt()[filepath.Ext(path)]
+}
+
 func GetAllLangs(lgs string) ([]string, error) {
 	allLang, err := configs.ParseLangs()
 	if err != nil {
@@ -24,3 +48,22 @@ func GetAllLangs(lgs string) ([]string, error) {
 	}
 	return retLangs, nil
 }
+
+// UnknownLanguages returns the names in lgs missing from the languages table.
+func UnknownLanguages(lgs string) ([]string, error) {
+	allLang, err := configs.ParseLangs()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, lang := range allLang {
+		known[strings.ToLower(lang.Name)] = true
+	}
+	var unknown []string
+	for _, lang := range SplitByDot(lgs) {
+		if !known[strings.ToLower(lang)] {
+			unknown = append(unknown, lang)
+		}
+	}
+	return unknown, nil
+}
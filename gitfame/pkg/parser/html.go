@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"html/template"
+	"io"
+)
+
+const barWidth = 200
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{"barWidth": func() int { return barWidth }}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gitfame</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; }
+th { cursor: pointer; user-select: none; }
+td.num { text-align: right; }
+rect { fill: #4c78a8; }
+</style>
+</head>
+<body>
+<table id="stats">
+<thead>
+<tr>{{range .Columns}}<th data-type="{{if .IsNumeric}}num{{else}}str{{end}}">{{.Header}}</th>{{end}}<th data-type="num">Share</th></tr>
+</thead>
+<tbody>
+{{- range .Rows}}
+{{template "row" .}}
+{{- end}}
+</tbody>
+{{- with .Total}}
+<tfoot>
+{{template "row" .}}
+</tfoot>
+{{- end}}
+</table>
+<script>
+document.querySelectorAll("#stats th").forEach(function (th, col) {
+  var asc = false;
+  th.addEventListener("click", function () {
+    var body = document.querySelector("#stats tbody");
+    var rows = Array.from(body.rows);
+    var num = th.dataset.type === "num";
+    asc = !asc;
+    rows.sort(function (a, b) {
+      var x = a.cells[col].dataset.value || a.cells[col].textContent;
+      var y = b.cells[col].dataset.value || b.cells[col].textContent;
+      var r = num ? parseFloat(x) - parseFloat(y) : x.localeCompare(y);
+      return asc ? r : -r;
+    });
+    rows.forEach(function (row) { body.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+{{define "row"}}<tr>{{range .Cells}}<td{{if .Numeric}} class="num"{{end}}>{{.Text}}</td>{{end}}<td data-value="{{.Share}}"><svg width="{{barWidth}}" height="12"><rect width="{{.Bar}}" height="12"></rect></svg> {{printf "%.1f" .Share}}%</td></tr>{{end}}`))
+
+type htmlCell struct {
+	Text    string
+	Numeric bool
+}
+
+type htmlRow struct {
+	Cells []htmlCell
+	Share float64
+	Bar   int
+}
+
+type HTMLFormatter struct {
+	FormatOptions
+}
+
+func (hf *HTMLFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	people := hf.TableRows(statsMap)
+	total := GetTotals(statsMap).Lines
+	rows := make([]htmlRow, 0, len(people))
+	var totalRow *htmlRow
+	for _, person := range people {
+		row := htmlRow{}
+		for _, col := range hf.Columns {
+			row.Cells = append(row.Cells, htmlCell{Text: col.Text(person), Numeric: col.IsNumeric()})
+		}
+		if total != 0 {
+			row.Share = 100 * float64(person.Lines) / float64(total)
+			row.Bar = person.Lines * barWidth / total
+		}
+		// the totals row stays in the footer when the table is sorted
+		if person.IsTotal {
+			totalRow = &row
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return htmlReport.Execute(w, struct {
+		Columns []Column
+		Rows    []htmlRow
+		Total   *htmlRow
+	}{hf.Columns, rows, totalRow})
+}
@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"encoding/json"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlameHunk is a record of --format=blame-jsonl: consecutive lines of a file
+// attributed to one commit.
+type BlameHunk struct {
+	Repo        string    `json:"repo,omitempty"` // repository label when several are analyzed
+	Path        string    `json:"path"`
+	StartLine   int       `json:"start_line"`
+	Lines       int       `json:"lines"`
+	Commit      string    `json:"commit"`
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email"`
+	AuthorTime  time.Time `json:"author_time"`
+	Committer   string    `json:"committer"`
+	Language    string    `json:"language,omitempty"`
+}
+
+// hunkCommits collects the commit metadata that git blame --porcelain prints
+// with the first hunk of each commit.
+type hunkCommits map[string]*BlameHunk
+
+func (c hunkCommits) add(commit, key, value string) {
+	meta, ok := c[commit]
+	if !ok {
+		meta = &BlameHunk{}
+		c[commit] = meta
+	}
+	switch key {
+	case "author":
+		meta.Author = value
+	case "author-mail":
+		meta.AuthorEmail = strings.Trim(value, "<>")
+	case "author-time":
+		if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+			meta.AuthorTime = time.Unix(sec, 0).UTC()
+		}
+	case "committer":
+		meta.Committer = value
+	}
+}
+
+// emitHunk passes the hunk of a porcelain group header
+// "<commit> <original line> <final line> <lines>" to p.OnHunk.
+func (p *Parser) emitHunk(file TreeFile, language string, header []string, commits hunkCommits) error {
+	start, _ := strconv.Atoi(header[2])
+	lines, _ := strconv.Atoi(header[3])
+	hunk := BlameHunk{Path: file.Path, StartLine: start, Lines: lines, Commit: header[0], Language: language}
+	if meta, ok := commits[hunk.Commit]; ok {
+		hunk.Author, hunk.AuthorEmail, hunk.AuthorTime, hunk.Committer = meta.Author, meta.AuthorEmail, meta.AuthorTime, meta.Committer
+	}
+	if hunk.Commit == NotCommittedHash {
+		hunk.Author, hunk.AuthorEmail, hunk.Committer = p.uncommittedAuthor, p.uncommittedEmail, p.uncommittedAuthor
+		if hunk.AuthorTime.IsZero() {
+			hunk.AuthorTime = p.Reference.UTC()
+		}
+	}
+	return p.OnHunk(hunk)
+}
+
+// HunkWriter is a Formatter that streams blame hunks while files are parsed
+// instead of printing aggregated stats.
+type HunkWriter interface {
+	Formatter
+	// Hunks returns the function writing each hunk to w.
+	Hunks(w io.Writer) func(BlameHunk) error
+}
+
+type BlameJSONLFormatter struct {
+	FormatOptions
+}
+
+// Output prints nothing: the hunks are already written.
+func (bf *BlameJSONLFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	return nil
+}
+
+func (bf *BlameJSONLFormatter) Hunks(w io.Writer) func(BlameHunk) error {
+	encoder := json.NewEncoder(w)
+	return func(hunk BlameHunk) error {
+		if err := encoder.Encode(hunk); err != nil {
+			return &errs.OutputError{Err: err}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"slices"
+	"strings"
+)
+
+// Interner assigns dense integer IDs to strings, so that each commit hash
+// and path is stored once per repository however many authors touch it.
+// It is not safe for concurrent writes.
+type Interner struct {
+	ids     map[string]uint32 // dropped by Freeze, rebuilt on the next write
+	strings []string
+}
+
+func NewInterner() *Interner {
+	return &Interner{ids: make(map[string]uint32)}
+}
+
+// ID returns the ID of s, interning a copy of it on first use: s is often
+// a slice of a larger buffer that must not be retained.
+func (in *Interner) ID(s string) uint32 {
+	if in.ids == nil {
+		in.ids = make(map[string]uint32, len(in.strings))
+		for id, str := range in.strings {
+			in.ids[str] = uint32(id)
+		}
+	}
+	if id, ok := in.ids[s]; ok {
+		return id
+	}
+	s = strings.Clone(s)
+	id := uint32(len(in.strings))
+	in.ids[s] = id
+	in.strings = append(in.strings, s)
+	return id
+}
+
+// Freeze frees the index used to intern new strings once they are all
+// known; the IDs stay valid.
+func (in *Interner) Freeze() {
+	in.ids = nil
+}
+
+// String returns the string interned as id.
+func (in *Interner) String(id uint32) string {
+	return in.strings[id]
+}
+
+// IDSet is a set of strings kept as a sorted slice of their IDs in an
+// Interner. An author usually touches a small part of the commits and paths
+// of a repository, so 4 bytes per member beat both a bitset over all IDs and
+// a map of strings.
+//
+// Added IDs are appended and merged into the sorted prefix lazily. The zero
+// value is an empty set that borrows the Interner of the first set merged
+// into it, or creates its own on the first Add.
+type IDSet struct {
+	table  *Interner
+	ids    []uint32
+	sorted int  // ids[:sorted] are sorted and unique
+	shared bool // table is borrowed and must be copied before interning into it
+}
+
+func NewIDSet(table *Interner) IDSet {
+	return IDSet{table: table}
+}
+
+// Add adds s to the set.
+func (s *IDSet) Add(str string) {
+	if s.table == nil {
+		s.table = NewInterner()
+	}
+	if s.shared {
+		s.own()
+	}
+	id := s.table.ID(str)
+	// consecutive adds of the same path or commit are common in blame output
+	if n := len(s.ids); n > 0 && s.ids[n-1] == id {
+		return
+	}
+	s.ids = append(s.ids, id)
+	if len(s.ids) > 2*s.sorted+64 {
+		s.compact()
+	}
+}
+
+// own replaces a borrowed table with a private copy holding the members.
+func (s *IDSet) own() {
+	table := NewInterner()
+	ids := make([]uint32, len(s.ids))
+	for i, id := range s.ids {
+		ids[i] = table.ID(s.table.String(id))
+	}
+	s.table, s.ids, s.sorted, s.shared = table, ids, 0, false
+	s.compact()
+}
+
+// compact sorts the IDs and drops duplicates.
+func (s *IDSet) compact() {
+	if s.sorted == len(s.ids) {
+		return
+	}
+	slices.Sort(s.ids)
+	s.ids = slices.Compact(s.ids)
+	if cap(s.ids) > 2*len(s.ids) {
+		s.ids = slices.Clone(s.ids)
+	}
+	s.sorted = len(s.ids)
+}
+
+// Union adds the members of other with prefix prepended. IDs are copied
+// directly when both sets use the same Interner and there is no prefix.
+func (s *IDSet) Union(other *IDSet, prefix string) {
+	if len(other.ids) == 0 {
+		return
+	}
+	if s.table == nil && prefix == "" {
+		s.table, s.shared = other.table, true
+	}
+	if s.table == other.table && prefix == "" {
+		s.ids = append(s.ids, other.ids...)
+		s.compact()
+		return
+	}
+	for _, id := range other.ids {
+		s.Add(prefix + other.table.String(id))
+	}
+	s.compact()
+}
+
+// Len returns the number of members. It doesn't modify the set, so that
+// stats shared between goroutines can be read concurrently.
+func (s *IDSet) Len() int {
+	if s.sorted == len(s.ids) {
+		return len(s.ids)
+	}
+	ids := slices.Clone(s.ids)
+	slices.Sort(ids)
+	return len(slices.Compact(ids))
+}
+
+// Strings returns the members in the order they were first interned.
+func (s *IDSet) Strings() []string {
+	ids := slices.Clone(s.ids)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = s.table.String(id)
+	}
+	return strs
+}
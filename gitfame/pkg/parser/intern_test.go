@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+)
+
+// blameRecord is an author line of git blame: author touched file in commit.
+type blameRecord struct {
+	author, file, commit int
+}
+
+// syntheticBlame imitates a large repository: every file is written by a
+// few authors, each with a few of their own commits.
+func syntheticBlame(files, commits, authors int) []blameRecord {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	var records []blameRecord
+	for file := 0; file < files; file++ {
+		for range 1 + rnd.IntN(4) {
+			author := rnd.IntN(authors)
+			for range 1 + rnd.IntN(3) {
+				// commit IDs are spread so that each author has own commits
+				commit := (author + authors*rnd.IntN(commits/authors)) % commits
+				records = append(records, blameRecord{author, file, commit})
+			}
+		}
+	}
+	return records
+}
+
+// The strings are formatted per record, as blame output yields a new string per line.
+func recordAuthor(r blameRecord) string { return fmt.Sprintf("Author %d", r.author) }
+func recordPath(r blameRecord) string {
+	return fmt.Sprintf("src/pkg%03d/file%06d.go", r.file%997, r.file)
+}
+func recordCommit(r blameRecord) string { return fmt.Sprintf("%040x", r.commit*2654435761+1) }
+
+// retainedHeap returns the heap still used by the result of build.
+func retainedHeap(build func() any) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	v := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+// mapStats are the sets of AuthorStats before they were interned.
+type mapStats struct {
+	commits, files map[string]bool
+}
+
+// buildMapSets collects the records the way AuthorStats did with map[string]bool sets.
+func buildMapSets(records []blameRecord) any {
+	stats := make(map[string]*mapStats)
+	for _, r := range records {
+		author := recordAuthor(r)
+		as, ok := stats[author]
+		if !ok {
+			as = &mapStats{commits: make(map[string]bool), files: make(map[string]bool)}
+			stats[author] = as
+		}
+		as.files[recordPath(r)] = true
+		as.commits[recordCommit(r)] = true
+	}
+	return stats
+}
+
+// buildIDSets collects the records as ParseFile does.
+func buildIDSets(records []blameRecord) any {
+	p := NewParser(&scaner.Scaner{})
+	for _, r := range records {
+		as := p.author(recordAuthor(r))
+		as.Files.Add(recordPath(r))
+		as.Commits.Add(recordCommit(r))
+	}
+	for _, as := range p.Stats {
+		as.Commits.compact()
+		as.Files.compact()
+	}
+	p.commitIDs.Freeze()
+	p.pathIDs.Freeze()
+	return p.Stats
+}
+
+func benchmarkAuthorSets(b *testing.B, build func([]blameRecord) any) {
+	records := syntheticBlame(100_000, 50_000, 1_000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	var retained uint64
+	for i := 0; i < b.N; i++ {
+		retained = retainedHeap(func() any { return build(records) })
+	}
+	b.ReportMetric(float64(retained)/(1<<20), "retained-MiB")
+}
+
+// go test -run=^$ -bench=AuthorSets ./pkg/parser compares the heap kept by
+// the commit and file sets of 1000 authors over 100k files and 50k commits.
+func BenchmarkAuthorSetsMap(b *testing.B)   { benchmarkAuthorSets(b, buildMapSets) }
+func BenchmarkAuthorSetsIDSet(b *testing.B) { benchmarkAuthorSets(b, buildIDSets) }
+
+func TestIDSetMatchesMapSets(t *testing.T) {
+	records := syntheticBlame(2_000, 1_000, 20)
+	maps := buildMapSets(records).(map[string]*mapStats)
+	sets := buildIDSets(records).(map[string]*AuthorStats)
+	require.Len(t, sets, len(maps))
+	for author, as := range sets {
+		require.Equal(t, len(maps[author].commits), as.Commits.Len(), author)
+		require.ElementsMatch(t, keys(maps[author].commits), as.Commits.Strings(), author)
+		require.Equal(t, len(maps[author].files), as.Files.Len(), author)
+		require.ElementsMatch(t, keys(maps[author].files), as.Files.Strings(), author)
+	}
+}
+
+func TestIDSetUnion(t *testing.T) {
+	var a, b IDSet
+	a.Add("x")
+	a.Add("y")
+	b.Add("y")
+	b.Add("z")
+
+	var same IDSet
+	same.Union(&a, "")
+	require.Equal(t, []string{"x", "y"}, same.Strings())
+	// b has its own table: the borrowed table of a must not change
+	same.Union(&b, "")
+	require.ElementsMatch(t, []string{"x", "y", "z"}, same.Strings())
+	require.Equal(t, []string{"x", "y"}, a.Strings())
+	require.Len(t, a.table.strings, 2)
+
+	var prefixed IDSet
+	prefixed.Union(&a, "repo/")
+	require.Equal(t, []string{"repo/x", "repo/y"}, prefixed.Strings())
+	require.Equal(t, 2, prefixed.Len())
+}
+
+func keys(m map[string]bool) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
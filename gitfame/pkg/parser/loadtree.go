@@ -1,41 +1,313 @@
 package parser
 
-import "strings"
+import (
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
 
-func (p *Parser) LoadTree() ([]string, error) {
-	out, err := CreateCmd("git", "ls-tree", "-r", p.Scaner.Revision, "--name-only", "--full-name", ".")
+// TreeFile is a file selected for blaming.
+type TreeFile struct {
+	Path     string      // path reported in stats, prefixed with the submodule path
+	Repo     *Repository // repository the file is blamed in
+	Revision string      // revision of Repo the file is blamed at
+	Name     string      // path inside Repo
+	Hash     string      // blob hash
+	Size     int64       // blob size in bytes
+	LFS      bool        // an LFS pointer counted with --lfs=file
+	Worktree bool        // blamed in the work tree, Revision is HEAD
+	Tracked  bool        // for work tree files, whether git tracks the file
+}
+
+type treeEntry struct {
+	Type string
+	Hash string
+	Size int64 // -1 for gitlinks
+	Path string
+}
+
+func listTree(repo *Repository, revision string, pathspecs []string) ([]treeEntry, error) {
+	if len(pathspecs) > 0 {
+		return listPathspecs(repo, revision, pathspecs)
+	}
+	out, err := repo.Git("ls-tree", "-r", "-z", "--long", "--full-name", revision)
+	if err != nil {
+		return nil, err
+	}
+	var entries []treeEntry
+	for _, line := range strings.Split(out, "\x00") {
+		if line == "" {
+			continue
+		}
+		info, path, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected ls-tree output: %q", line)
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			size = -1
+		}
+		entries = append(entries, treeEntry{Type: fields[1], Hash: fields[2], Size: size, Path: path})
+	}
+	return entries, nil
+}
+
+// listPathspecs lists the entries of revision matching pathspecs. ls-tree
+// doesn't support pathspec magic, so the tree is diffed against the empty tree
+// and the sizes of the blobs are read afterwards.
+func listPathspecs(repo *Repository, revision string, pathspecs []string) ([]treeEntry, error) {
+	hashEmpty := repo.Command("hash-object", "-t", "tree", "--stdin")
+	hashEmpty.Stdin = strings.NewReader("")
+	emptyTree, err := RunCmd(hashEmpty)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"diff-tree", "-r", "-z", "--no-renames", emptyTree, revision, "--"}, pathspecs...)
+	out, err := repo.Git(args...)
+	if err != nil {
+		// pathspecs git rejects fail against the empty tree as well
+		check := append([]string{"diff-tree", emptyTree, emptyTree, "--"}, pathspecs...)
+		if _, checkErr := repo.Git(check...); checkErr != nil {
+			return nil, &errs.PathspecError{Pathspecs: pathspecs, Err: checkErr}
+		}
+		return nil, err
+	}
+	// records are ":<old mode> <new mode> <old hash> <new hash> A" and a path
+	fields := strings.Split(out, "\x00")
+	var entries []treeEntry
+	var hashes []string
+	for i := 0; i+1 < len(fields); i += 2 {
+		info := strings.Fields(fields[i])
+		if len(info) != 5 {
+			return nil, fmt.Errorf("unexpected diff-tree output: %q", fields[i])
+		}
+		entry := treeEntry{Type: "blob", Hash: info[3], Size: -1, Path: fields[i+1]}
+		if info[1] == "160000" {
+			entry.Type = "commit"
+		} else {
+			hashes = append(hashes, entry.Hash)
+		}
+		entries = append(entries, entry)
+	}
+	sizes, err := blobSizes(repo, hashes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if size, ok := sizes[entries[i].Hash]; ok {
+			entries[i].Size = size
+		}
+	}
+	return entries, nil
+}
+
+// blobSizes reads the sizes of blobs with git cat-file --batch-check.
+func blobSizes(repo *Repository, hashes []string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	if len(hashes) == 0 {
+		return sizes, nil
+	}
+	cmd := repo.Command("cat-file", "--batch-check")
+	cmd.Stdin = strings.NewReader(strings.Join(hashes, "\n") + "\n")
+	out, err := RunCmd(cmd)
 	if err != nil {
 		return nil, err
 	}
-	if len(out) == 0 {
-		return nil, nil
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected cat-file output: %q", line)
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sizes[fields[0]] = size
+	}
+	return sizes, nil
+}
+
+// loadFiles lists blobs of repo at revision; with --recurse-submodules gitlinks
+// are expanded into the files of the submodule at the recorded commit.
+func (p *Parser) loadFiles(repo *Repository, revision, prefix string) ([]TreeFile, error) {
+	var pathspecs []string
+	if prefix == "" {
+		pathspecs = p.Scaner.Pathspecs
 	}
-	files := strings.Split(out, "\n")
+	entries, err := listTree(repo, revision, pathspecs)
 	if err != nil {
 		return nil, err
 	}
+	var files []TreeFile
+	for _, entry := range entries {
+		switch entry.Type {
+		case "blob":
+			files = append(files, TreeFile{
+				Path:     prefix + entry.Path,
+				Repo:     repo,
+				Revision: revision,
+				Name:     entry.Path,
+				Hash:     entry.Hash,
+				Size:     entry.Size,
+			})
+		case "commit":
+			if !p.Scaner.RecurseSubmodules {
+				continue
+			}
+			subRepo, err := repo.Submodule(entry.Path)
+			if err != nil {
+				return nil, fmt.Errorf("submodule %s is not initialized, run 'git submodule update --init --recursive'", prefix+entry.Path)
+			}
+			if _, err := subRepo.Git("cat-file", "-e", entry.Hash+"^{commit}"); err != nil {
+				return nil, fmt.Errorf("submodule %s: commit %s is not available, run 'git submodule update --init --recursive'", prefix+entry.Path, entry.Hash)
+			}
+			subFiles, err := p.loadFiles(subRepo, entry.Hash, prefix+entry.Path+"/")
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, subFiles...)
+		}
+	}
+	return files, nil
+}
+
+// loadWorktree lists the tracked files that exist in the work tree of repo
+// and, with --include-untracked, the untracked files that are not ignored.
+func (p *Parser) loadWorktree(repo *Repository, head string) ([]TreeFile, error) {
+	if repo.WorkTree == "" {
+		return nil, errs.InvalidFlag("worktree", "%s has no working tree", p.Scaner.Repository)
+	}
+	// tracked files first, then untracked ones
+	lists := [][]string{{"ls-files", "-z", "--full-name"}}
+	if p.Scaner.IncludeUntracked {
+		lists = append(lists, []string{"ls-files", "-z", "--full-name", "--others", "--exclude-standard"})
+	}
+	if len(p.Scaner.Pathspecs) > 0 {
+		// git runs in the root of the work tree, so pathspecs are relative to it as in the tree listing
+		for i := range lists {
+			lists[i] = append(append(lists[i], "--"), p.Scaner.Pathspecs...)
+		}
+	}
+	var files []TreeFile
+	seen := make(map[string]bool)
+	for i, args := range lists {
+		out, err := repo.WorkTreeGit(args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range strings.Split(out, "\x00") {
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			// deleted files, submodules and nested repositories are left out
+			info, err := os.Lstat(filepath.Join(repo.WorkTree, name))
+			if err != nil || info.IsDir() {
+				continue
+			}
+			files = append(files, TreeFile{
+				Path:     name,
+				Repo:     repo,
+				Revision: head,
+				Name:     name,
+				Size:     info.Size(),
+				Worktree: true,
+				Tracked:  i == 0,
+			})
+		}
+	}
+	return files, nil
+}
+
+// uncommittedIdentity returns the author of uncommitted lines: --worktree-author,
+// or user.name and user.email of the repository.
+func uncommittedIdentity(repo *Repository, author string) (string, string) {
+	if author != "" {
+		return author, NotCommittedEmail
+	}
+	name, _ := repo.Git("config", "user.name")
+	if name == "" {
+		return NotCommittedAuthor, NotCommittedEmail
+	}
+	email, _ := repo.Git("config", "user.email")
+	if email == "" {
+		email = NotCommittedEmail
+	}
+	return name, email
+}
+
+func (p *Parser) LoadTree() ([]TreeFile, error) {
+	repo, err := ResolveRepository(p.Scaner.Repository)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := repo.ResolveRevision(p.Scaner.Revision)
+	if err != nil {
+		return nil, &errs.UnknownRevisionError{Repository: p.Scaner.Repository, Revision: p.Scaner.Revision}
+	}
+	commitTime, err := repo.Git("show", "-s", "--format=%ct", revision)
+	if err != nil {
+		return nil, err
+	}
+	if sec, err := strconv.ParseInt(commitTime, 10, 64); err == nil {
+		p.Reference = time.Unix(sec, 0)
+	}
+	var files []TreeFile
+	if p.Scaner.Worktree {
+		p.Reference = time.Now()
+		p.uncommittedAuthor, p.uncommittedEmail = uncommittedIdentity(repo, p.Scaner.WorktreeAuthor)
+		files, err = p.loadWorktree(repo, revision)
+	} else {
+		files, err = p.loadFiles(repo, revision, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Scaner.FilesFrom != "" {
+		at := p.Scaner.Revision
+		if p.Scaner.Worktree {
+			at = "the working tree"
+		}
+		if files, err = selectFiles(files, p.Scaner.Files, at); err != nil {
+			return nil, err
+		}
+		if p.Scaner.IgnoreFilters {
+			return p.skipFiles(files)
+		}
+	}
 	extensions := SplitByDot(p.Scaner.Extensions)
 	langs, err := GetAllLangs(p.Scaner.Languages)
 	if err != nil {
 		return nil, err
 	}
-	exclude := SplitByDot(p.Scaner.Exclude)
-	restrictTo := SplitByDot(p.Scaner.RestrictTo)
-	needFiles := make([]string, 0)
+	exclude, err := CompilePatterns("exclude", p.Scaner.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	restrictTo, err := CompilePatterns("restrict-to", p.Scaner.RestrictTo)
+	if err != nil {
+		return nil, err
+	}
+	needFiles := make([]TreeFile, 0)
 	for _, file := range files {
-		if exclude != nil && IsFilenameMatchPattern(file, exclude) {
+		if exclude != nil && IsFilenameMatchPattern(file.Path, exclude) {
 			continue
 		}
-		if restrictTo != nil && !IsFilenameMatchPattern(file, restrictTo) {
+		if restrictTo != nil && !IsFilenameMatchPattern(file.Path, restrictTo) {
 			continue
 		}
-		if !isExtensionMatch(file, extensions) {
+		if !isExtensionMatch(file.Path, extensions) {
 			continue
 		}
-		if !isExtensionMatch(file, langs) {
+		if !isExtensionMatch(file.Path, langs) {
 			continue
 		}
 		needFiles = append(needFiles, file)
 	}
-	return needFiles, nil
+	return p.skipFiles(needFiles)
 }
@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+)
+
+// submoduleRepo creates a superproject with a submodule at vendor/sub.
+func submoduleRepo(t *testing.T) *gittest.Repo {
+	sub := gittest.New(t)
+	sub.Commit("Sub Dev", map[string]string{"lib/x.go": "package lib\n\nvar X = 1\n", "README.md": "sub\n"})
+	super := gittest.New(t)
+	super.Commit("Alice", map[string]string{"main.go": "package main\n"})
+	super.Git("submodule", "add", "-q", sub.Dir, "vendor/sub")
+	super.Git("-c", "user.name=Alice", "-c", "user.email=alice@example.com", "commit", "-q", "-m", "add submodule")
+	return super
+}
+
+// treePaths lists the paths LoadTree selects for the command line args.
+func treePaths(t *testing.T, args ...string) ([]string, error) {
+	p := NewParser(scan(t, args...))
+	files, err := p.LoadTree()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	return paths, nil
+}
+
+func TestRecurseSubmodules(t *testing.T) {
+	super := submoduleRepo(t)
+
+	paths, err := treePaths(t, "--repository", super.Dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{".gitmodules", "main.go"}, paths)
+
+	paths, err = treePaths(t, "--repository", super.Dir, "--recurse-submodules")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{".gitmodules", "main.go", "vendor/sub/README.md", "vendor/sub/lib/x.go"}, paths)
+
+	// filters see the paths prefixed with the submodule path
+	paths, err = treePaths(t, "--repository", super.Dir, "--recurse-submodules", "--exclude", "^vendor/sub/lib/")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{".gitmodules", "main.go", "vendor/sub/README.md"}, paths)
+
+	paths, err = treePaths(t, "--repository", super.Dir, "--recurse-submodules", "--restrict-to", "^vendor/sub/")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"vendor/sub/README.md", "vendor/sub/lib/x.go"}, paths)
+
+	lines := collect(t, scan(t, "--repository", super.Dir, "--recurse-submodules", "--extensions", ".go"))
+	require.Equal(t, map[string]int{"Alice": 1, "Sub Dev": 3}, lines)
+}
+
+func TestRecurseSubmodulesNotCheckedOut(t *testing.T) {
+	super := submoduleRepo(t)
+
+	// a fresh clone has neither the checkout nor the module in the git directory
+	clone := filepath.Join(t.TempDir(), "clone")
+	super.Git("clone", "-q", super.Dir, clone)
+	_, err := treePaths(t, "--repository", clone, "--recurse-submodules")
+	require.ErrorContains(t, err, "submodule vendor/sub is not initialized")
+
+	// after deinit the module stored in the git directory is used
+	cloned := &gittest.Repo{TB: t, Dir: clone}
+	cloned.Git("submodule", "update", "-q", "--init")
+	cloned.Git("submodule", "deinit", "-q", "-f", "vendor/sub")
+	paths, err := treePaths(t, "--repository", clone, "--recurse-submodules")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{".gitmodules", "main.go", "vendor/sub/README.md", "vendor/sub/lib/x.go"}, paths)
+}
+
+func TestPathspecErrors(t *testing.T) {
+	r := gittest.New(t)
+	r.Commit("Alice", map[string]string{"a.go": "package a\n"})
+
+	_, err := treePaths(t, "--repository", r.Dir, "--", ":(bogus)a.go")
+	var pathspecErr *errs.PathspecError
+	require.ErrorAs(t, err, &pathspecErr)
+	require.Equal(t, errs.ExitInvalidFlags, errs.ExitCode(err))
+
+	// other failures of git are not blamed on the pathspecs
+	repo, err := ResolveRepository(r.Dir)
+	require.NoError(t, err)
+	_, err = listPathspecs(repo, strings.Repeat("1", 40), []string{"a.go"})
+	require.NotErrorAs(t, err, &pathspecErr)
+	require.Equal(t, errs.ExitGitFailure, errs.ExitCode(err))
+}
@@ -3,17 +3,32 @@ package parser
 import (
 	"bufio"
 	"fmt"
-	"os"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"io"
+	"math"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func IsFilenameMatchPattern(filename string, patterns []string) bool {
+// CompilePatterns compiles the comma separated patterns of flag.
+func CompilePatterns(flag, list string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, pattern := range SplitByDot(list) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errs.InvalidFlag(flag, "invalid pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+func IsFilenameMatchPattern(filename string, patterns []*regexp.Regexp) bool {
 	for _, pattern := range patterns {
-		matched, _ := regexp.MatchString(pattern, filename)
-		if matched {
+		if pattern.MatchString(filename) {
 			return true
 		}
 	}
@@ -29,70 +44,151 @@ func isExtensionMatch(filename string, expectedExts []string) bool {
 	return len(expectedExts) == 0
 }
 
-func (p *Parser) ParseLastCommiter(file string) error {
-	out, err := CreateCmd("git", "log", "-1", "--pretty=format:%H,%an", p.Scaner.Revision, "--", file)
+// newLineScanner scans the lines of git output however long they are: blamed
+// files may have lines longer than the default token limit of bufio.Scanner.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, math.MaxInt)
+	return scanner
+}
+
+func (p *Parser) ParseLastCommiter(file TreeFile) error {
+	format := "--pretty=format:%H,%at,%ae,%an"
+	if p.Scaner.UseCommitter {
+		format = "--pretty=format:%H,%ct,%ce,%cn"
+	}
+	out, err := file.Repo.Git("log", "-1", format, file.Revision, "--", file.Name)
 	if err != nil {
 		return err
 	}
-	commitInfo := strings.SplitN(out, ",", 2)
+	if out == "" && file.Worktree {
+		// added to the index but never committed
+		p.addUncommitted(file, 0)
+		return nil
+	}
+	commitInfo := strings.SplitN(out, ",", 4)
 
 	//log.Println(commitInfo)
-	if len(commitInfo) != 2 {
+	if len(commitInfo) != 4 {
 		return fmt.Errorf("unexpected output format: %s", out)
 	}
-	commitID, author := commitInfo[0], commitInfo[1]
-	if _, ok := p.Stats[author]; !ok {
-		p.Stats[author] = NewAuthorStats()
+	commitID, timestamp, email, author := commitInfo[0], commitInfo[1], commitInfo[2], commitInfo[3]
+	as := p.author(author)
+	as.Files.Add(file.Path)
+	as.Commits.Add(commitID)
+	as.Emails[email] = true
+	if sec, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		as.AddCommitTime(time.Unix(sec, 0))
 	}
-	p.Stats[author].Files[file] = true
-	p.Stats[author].Commits[commitID] = true
 	return nil
 }
 
-func (p *Parser) ParseFile(file string) error {
-	out, err := CreateCmd("git", "blame", p.Scaner.Revision, "--porcelain", file)
-	if err != nil {
-		return err
+func (p *Parser) ParseFile(file TreeFile) error {
+	if file.LFS {
+		return p.ParseLastCommiter(file)
+	}
+	if file.Worktree && !file.Tracked {
+		return p.parseUntracked(file)
+	}
+	cmd := file.Repo.Command("blame", file.Revision, "--porcelain", "--", file.Name)
+	if file.Worktree {
+		cmd = file.Repo.WorkTreeCommand("blame", "--porcelain", "--", file.Name)
 	}
 	prefixStart := "author"
 	if p.Scaner.UseCommitter {
 		prefixStart = "committer"
 	}
-	scanner := bufio.NewScanner(strings.NewReader(out))
 	fl := 0
 	authorByCommit := make(map[string]string)
-	for scanner.Scan() {
-		line := strings.Split(scanner.Text(), " ")
-		commit := line[0]
-		linesCnt, _ := strconv.Atoi(line[len(line)-1])
-		if author, ok := authorByCommit[commit]; ok {
-			p.Stats[author].LinesCnt += linesCnt
-		}
-		i := 0
-		for i < linesCnt {
-			if !scanner.Scan() {
-				break
+	timeByCommit := make(map[string]time.Time)
+	linesByCommit := make(map[string]int)
+	var commits hunkCommits
+	var language string
+	if p.OnHunk != nil {
+		commits = make(hunkCommits)
+		language = LanguageOf(file.Path)
+	}
+	if p.BlameSlots != nil {
+		p.BlameSlots <- struct{}{}
+	}
+	// the output is parsed while git blame runs instead of being buffered
+	err := StreamCmd(cmd, func(out io.Reader) error {
+		scanner := newLineScanner(out)
+		for scanner.Scan() {
+			line := strings.Split(scanner.Text(), " ")
+			commit := line[0]
+			linesCnt, _ := strconv.Atoi(line[len(line)-1])
+			linesByCommit[commit] += linesCnt
+			if author, ok := authorByCommit[commit]; ok {
+				p.Stats[author].LinesCnt += linesCnt
 			}
-			line := scanner.Text()
-			lineCopy := strings.Split(line, " ")
-			if strings.HasPrefix(lineCopy[0], "\t") {
-				i++
-			} else {
-				if lineCopy[0] == prefixStart {
-					author := strings.TrimSpace(strings.TrimPrefix(line, prefixStart+" "))
-					fl++
-					if _, ok := authorByCommit[commit]; !ok {
-						authorByCommit[commit] = author
+			i := 0
+			for i < linesCnt {
+				if !scanner.Scan() {
+					break
+				}
+				line := scanner.Text()
+				lineCopy := strings.Split(line, " ")
+				if strings.HasPrefix(lineCopy[0], "\t") {
+					i++
+				} else {
+					if commits != nil {
+						commits.add(commit, lineCopy[0], strings.TrimPrefix(line, lineCopy[0]+" "))
+					}
+					if author, ok := authorByCommit[commit]; ok {
+						switch lineCopy[0] {
+						case prefixStart + "-mail":
+							email := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, lineCopy[0]+" ")), "<>")
+							if commit == NotCommittedHash {
+								email = p.uncommittedEmail
+							}
+							p.Stats[author].Emails[email] = true
+						case prefixStart + "-time":
+							if sec, err := strconv.ParseInt(lineCopy[1], 10, 64); err == nil {
+								p.Stats[author].AddCommitTime(time.Unix(sec, 0))
+								timeByCommit[commit] = time.Unix(sec, 0)
+							}
+						}
 					}
-					if _, ok := p.Stats[author]; !ok {
-						p.Stats[author] = NewAuthorStats()
+					if lineCopy[0] == prefixStart {
+						author := strings.TrimSpace(strings.TrimPrefix(line, prefixStart+" "))
+						if commit == NotCommittedHash {
+							author = p.uncommittedAuthor
+						}
+						fl++
+						if _, ok := authorByCommit[commit]; !ok {
+							authorByCommit[commit] = author
+						}
+						as := p.author(author)
+						as.Files.Add(file.Path)
+						as.Commits.Add(commit)
+						as.LinesCnt += linesCnt
 					}
-					p.Stats[author].Files[file] = true
-					p.Stats[author].Commits[commit] = true
-					p.Stats[author].LinesCnt += linesCnt
+				}
+			}
+			if commits != nil && len(line) == 4 {
+				if err := p.emitHunk(file, language, line, commits); err != nil {
+					return err
 				}
 			}
 		}
+		return scanner.Err()
+	})
+	if p.BlameSlots != nil {
+		<-p.BlameSlots
+	}
+	if err != nil {
+		return err
+	}
+	for commit, t := range timeByCommit {
+		p.Stats[authorByCommit[commit]].AddLineAge(p.Reference, t, linesByCommit[commit])
+	}
+	if p.FileLines != nil && len(authorByCommit) > 0 {
+		lines := make(map[string]int)
+		for commit, author := range authorByCommit {
+			lines[author] += linesByCommit[commit]
+		}
+		p.FileLines[file.Path] = lines
 	}
 	if fl == 0 {
 		err = p.ParseLastCommiter(file)
@@ -100,21 +196,34 @@ func (p *Parser) ParseFile(file string) error {
 	return err
 }
 
-func (p *Parser) ParseFiles(files []string) error {
-	for _, file := range files {
+func (p *Parser) ParseFiles(files []TreeFile) (err error) {
+	if p.Progress != nil {
+		name := p.Name
+		if name == "" {
+			name = p.Scaner.Repository
+		}
+		p.Progress.Start(name, len(files))
+		defer func() { p.Progress.Finish(err) }()
+	}
+	for i, file := range files {
+		if p.Progress != nil {
+			p.Progress.File(i, file.Path)
+		}
 		err := p.ParseFile(file)
 		if err != nil {
-			return err
+			return &errs.FileError{Path: file.Path, Err: err}
 		}
 	}
+	for _, as := range p.Stats {
+		as.Commits.compact()
+		as.Files.compact()
+	}
+	p.commitIDs.Freeze()
+	p.pathIDs.Freeze()
 	return nil
 }
 
 func (p *Parser) DoRoutine() error {
-	err := os.Chdir(p.Scaner.Repository)
-	if err != nil {
-		return err
-	}
 	files, err := p.LoadTree()
 	if err != nil {
 		return err
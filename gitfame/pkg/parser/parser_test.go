@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+)
+
+// blameRepo creates a repository with a file of lines lines written in
+// several commits.
+func blameRepo(tb testing.TB, lines int) *Repository {
+	r := gittest.New(tb)
+	var content strings.Builder
+	for commit := 0; commit < 10; commit++ {
+		for i := 0; i < lines/10; i++ {
+			fmt.Fprintf(&content, "line %d of commit %d\n", i, commit)
+		}
+		r.Commit("Bench", map[string]string{"big.txt": content.String()})
+	}
+	repo, err := ResolveRepository(r.Dir)
+	require.NoError(tb, err)
+	return repo
+}
+
+// scanLines reads r line by line as ParseFile does.
+func scanLines(r io.Reader) error {
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+	}
+	return scanner.Err()
+}
+
+// BenchmarkBlameOutput compares reading git blame --porcelain of a 200k line
+// file into one string, as CreateCmd does, with streaming it.
+func BenchmarkBlameOutput(b *testing.B) {
+	repo := blameRepo(b, 200_000)
+	args := []string{"blame", "HEAD", "--porcelain", "--", "big.txt"}
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out, err := repo.Git(args...)
+			require.NoError(b, err)
+			require.NoError(b, scanLines(strings.NewReader(out)))
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, StreamCmd(repo.Command(args...), scanLines))
+		}
+	})
+}
+
+func TestParseFileStreamsBlame(t *testing.T) {
+	repo := blameRepo(t, 1_000)
+	p := NewParser(&scaner.Scaner{})
+	require.NoError(t, p.ParseFiles([]TreeFile{{Path: "big.txt", Repo: repo, Revision: "HEAD", Name: "big.txt"}}))
+	require.Len(t, p.Stats, 1)
+	as := p.Stats["Bench"]
+	require.Equal(t, 1_000, as.LinesCnt)
+	require.Equal(t, 10, as.Commits.Len())
+	require.Equal(t, []string{"big.txt"}, as.Files.Strings())
+}
+
+func TestParseFileLongLine(t *testing.T) {
+	r := gittest.New(t)
+	// longer than the 64 KiB default token limit of bufio.Scanner
+	long := strings.Repeat("x", 70_000) + "\n"
+	r.Commit("Alice", map[string]string{"data.txt": long + "a\n"})
+	r.Commit("Bob", map[string]string{"data.txt": long + "a\nb1\nb2\n"})
+	repo, err := ResolveRepository(r.Dir)
+	require.NoError(t, err)
+
+	p := NewParser(&scaner.Scaner{})
+	require.NoError(t, p.ParseFiles([]TreeFile{{Path: "data.txt", Repo: repo, Revision: "HEAD", Name: "data.txt"}}))
+	require.Equal(t, map[string]int{"Alice": 2, "Bob": 2}, authorLines(p.Stats))
+}
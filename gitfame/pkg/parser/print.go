@@ -4,31 +4,23 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"os"
-	"strconv"
+	"io"
+	"strings"
 )
 
 type Formatter interface {
-	Output(map[string]*AuthorStats) error
+	Output(w io.Writer, statsMap map[string]*AuthorStats) error
+	// SetSkipped passes the counts of files left out of blame, reported by json formats.
+	SetSkipped(skipped SkippedFiles)
 }
 
 type TabularFormatter struct {
-	SortOrder []string
+	FormatOptions
 }
-type Column struct {
-	Header string
-	Getter func(person StatsAuthor) string
-}
-
-func (tf *TabularFormatter) Output(statsMap map[string]*AuthorStats) error {
-	columns := []Column{
-		{"Name", func(p StatsAuthor) string { return p.Name }},
-		{"Lines", func(p StatsAuthor) string { return strconv.Itoa(p.Lines) }},
-		{"Commits", func(p StatsAuthor) string { return strconv.Itoa(p.Commits) }},
-		{"Files", func(p StatsAuthor) string { return strconv.Itoa(p.Files) }},
-	}
 
-	people := GetStats(statsMap, tf.SortOrder)
+func (tf *TabularFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	columns := tf.Columns
+	people := tf.TableRows(statsMap)
 
 	colWidths := make([]int, len(columns))
 	for i, col := range columns {
@@ -37,7 +29,7 @@ func (tf *TabularFormatter) Output(statsMap map[string]*AuthorStats) error {
 
 	for _, person := range people {
 		for i, col := range columns {
-			field := col.Getter(person)
+			field := col.Text(person)
 			if len(field) > colWidths[i] {
 				colWidths[i] = len(field)
 			}
@@ -46,19 +38,19 @@ func (tf *TabularFormatter) Output(statsMap map[string]*AuthorStats) error {
 
 	for i, col := range columns {
 		if i != len(columns)-1 {
-			fmt.Printf("%-*s ", colWidths[i], col.Header)
+			fmt.Fprintf(w, "%-*s ", colWidths[i], col.Header)
 		} else {
-			fmt.Println(col.Header)
+			fmt.Fprintln(w, col.Header)
 		}
 	}
 
 	for _, person := range people {
 		for i, col := range columns {
-			field := col.Getter(person)
+			field := col.Text(person)
 			if i != len(columns)-1 {
-				fmt.Printf("%-*s ", colWidths[i], field)
+				fmt.Fprintf(w, "%-*s ", colWidths[i], field)
 			} else {
-				fmt.Println(field)
+				fmt.Fprintln(w, field)
 			}
 		}
 	}
@@ -67,25 +59,26 @@ func (tf *TabularFormatter) Output(statsMap map[string]*AuthorStats) error {
 }
 
 type CSVFormatter struct {
-	SortOrder []string
+	FormatOptions
 }
 
-func (cf *CSVFormatter) Output(statsMap map[string]*AuthorStats) error {
-	stats := GetStats(statsMap, cf.SortOrder)
-	writer := csv.NewWriter(os.Stdout)
+func (cf *CSVFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	columns := cf.Columns
+	writer := csv.NewWriter(w)
 	defer writer.Flush()
 
-	headers := []string{"Name", "Lines", "Commits", "Files"}
+	headers := make([]string, len(columns))
+	for i, col := range columns {
+		headers[i] = col.Header
+	}
 	if err := writer.Write(headers); err != nil {
 		return err
 	}
 
-	for _, stat := range stats {
-		row := []string{
-			stat.Name,
-			strconv.Itoa(stat.Lines),
-			strconv.Itoa(stat.Commits),
-			strconv.Itoa(stat.Files),
+	for _, stat := range cf.TableRows(statsMap) {
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			row[i] = col.Text(stat)
 		}
 		if err := writer.Write(row); err != nil {
 			return err
@@ -95,33 +88,104 @@ func (cf *CSVFormatter) Output(statsMap map[string]*AuthorStats) error {
 	return nil
 }
 
+// jsonRecords returns author summaries in the shape used by the json formats.
+func (o *FormatOptions) jsonRecords(statsMap map[string]*AuthorStats) []any {
+	rows := o.Rows(statsMap)
+	records := make([]any, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, newJSONObject(o.Columns, row))
+	}
+	return records
+}
+
+// jsonTotals returns the totals object built from the columns that support totals.
+func (o *FormatOptions) jsonTotals(statsMap map[string]*AuthorStats) jsonObject {
+	var columns []Column
+	for _, col := range o.Columns {
+		if col.Total {
+			columns = append(columns, col)
+		}
+	}
+	return newJSONObject(columns, o.TotalRow(statsMap))
+}
+
 type JSONFormatter struct {
-	SortOrder []string
+	FormatOptions
 }
 
-func (jf *JSONFormatter) Output(statsMap map[string]*AuthorStats) error {
-	summaries := GetStats(statsMap, jf.SortOrder)
-	jsonData, err := json.Marshal(summaries)
+// Output prints an array of authors. With --totals or --report-skipped it
+// prints an object with the authors instead, whatever was skipped.
+func (jf *JSONFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	var data any = jf.jsonRecords(statsMap)
+	if jf.Totals || jf.ReportSkipped {
+		report := struct {
+			Authors any           `json:"authors"`
+			Totals  *jsonObject   `json:"totals,omitempty"`
+			Skipped *SkippedFiles `json:"skipped,omitempty"`
+		}{Authors: data}
+		if jf.Totals {
+			totals := jf.jsonTotals(statsMap)
+			report.Totals = &totals
+		}
+		if jf.ReportSkipped {
+			skipped := make(SkippedFiles)
+			skipped.Add(jf.Skipped)
+			report.Skipped = &skipped
+		}
+		data = report
+	}
+	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	fmt.Println(string(jsonData))
+	fmt.Fprintln(w, string(jsonData))
 	return nil
 }
 
 type JSONLinesFormatter struct {
-	SortOrder []string
+	FormatOptions
 }
 
-func (jlf *JSONLinesFormatter) Output(statsMap map[string]*AuthorStats) error {
-	people := GetStats(statsMap, jlf.SortOrder)
-	for _, person := range people {
-		jsonData, err := json.Marshal(person)
+// Output prints a line per author and nothing else.
+func (jlf *JSONLinesFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	for _, record := range jlf.jsonRecords(statsMap) {
+		jsonData, err := json.Marshal(record)
 		if err != nil {
 			return err
 		}
-		//log.Println(string(jsonData))
-		fmt.Println(string(jsonData))
+		fmt.Fprintln(w, string(jsonData))
+	}
+	return nil
+}
+
+type MarkdownFormatter struct {
+	FormatOptions
+}
+
+func escapeMarkdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+func (mf *MarkdownFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	columns := mf.Columns
+	header := make([]string, len(columns))
+	align := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Header
+		if col.IsNumeric() {
+			align[i] = strings.Repeat("-", len(col.Header)+1) + ":"
+		} else {
+			align[i] = strings.Repeat("-", len(col.Header)+2)
+		}
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+	fmt.Fprintf(w, "|%s|\n", strings.Join(align, "|"))
+	for _, person := range mf.TableRows(statsMap) {
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			row[i] = escapeMarkdownCell(col.Text(person))
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
 	}
 	return nil
 }
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Repository locates a git repository; WorkTree is empty for bare repositories.
+type Repository struct {
+	GitDir   string
+	WorkTree string
+}
+
+// Git runs git against the repository without depending on the working directory.
+func (r *Repository) Git(args ...string) (string, error) {
+	return CreateCmd("git", append([]string{"--git-dir=" + r.GitDir}, args...)...)
+}
+
+// Command prepares a git command against the repository.
+func (r *Repository) Command(args ...string) *exec.Cmd {
+	return exec.Command("git", append([]string{"--git-dir=" + r.GitDir}, args...)...)
+}
+
+// WorkTreeCommand prepares a git command running in the work tree of the repository.
+func (r *Repository) WorkTreeCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.WorkTree
+	return cmd
+}
+
+// WorkTreeGit runs git in the work tree of the repository.
+func (r *Repository) WorkTreeGit(args ...string) (string, error) {
+	return RunCmd(r.WorkTreeCommand(args...))
+}
+
+func ResolveRepository(path string) (*Repository, error) {
+	gitDir, err := CreateCmdInDir(path, "git", "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return nil, &errs.NotRepositoryError{Path: path, Err: err}
+	}
+	// fails for bare repositories
+	workTree, _ := CreateCmdInDir(path, "git", "rev-parse", "--show-toplevel")
+	return &Repository{GitDir: gitDir, WorkTree: workTree}, nil
+}
+
+// ResolveRevision returns the hash of the commit named by revision.
+func (r *Repository) ResolveRevision(revision string) (string, error) {
+	if strings.HasPrefix(revision, "-") {
+		return "", fmt.Errorf("invalid revision %q", revision)
+	}
+	return r.Git("rev-parse", "--verify", "--quiet", revision+"^{commit}")
+}
+
+// Submodule locates the repository of the submodule at path: its checkout in
+// the work tree if initialized, or the module stored in the git directory.
+func (r *Repository) Submodule(path string) (*Repository, error) {
+	if r.WorkTree != "" {
+		dir := filepath.Join(r.WorkTree, path)
+		sub, err := ResolveRepository(dir)
+		// an uninitialized submodule directory resolves to the superproject
+		if err == nil && sub.WorkTree != "" && sub.WorkTree != r.WorkTree {
+			return sub, nil
+		}
+	}
+	modules := filepath.Join(r.GitDir, "modules", path)
+	if info, err := os.Stat(modules); err == nil && info.IsDir() {
+		return &Repository{GitDir: modules}, nil
+	}
+	return nil, fmt.Errorf("submodule %s is not initialized", path)
+}
+
+// bundleSignatures start the supported versions of git bundle files.
+var bundleSignatures = []string{"# v2 git bundle\n", "# v3 git bundle\n"}
+
+// IsBundle reports whether path is a git bundle file, judging by its signature.
+func IsBundle(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	header := make([]byte, len(bundleSignatures[0]))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return slices.Contains(bundleSignatures, string(header))
+}
+
+// OpenBundle clones a bundle file into a temporary bare repository.
+func OpenBundle(path string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "gitfame-bundle-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	if _, err := CreateCmd("git", "clone", "--quiet", "--mirror", path, dir); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("bundle %s: %w", path, err)
+	}
+	return dir, cleanup, nil
+}
+
+// OpenRepositories lists the repositories to analyze and clones bundle files
+// into temporary bare repositories, removed by the returned function.
+// scan.Repository is set to the first repository.
+func OpenRepositories(scan *scaner.Scaner) ([]RepoSpec, func(), error) {
+	specs, err := RepoSpecs(scan)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	var cleanups []func()
+	cleanup := func() {
+		for _, f := range cleanups {
+			f()
+		}
+	}
+	for i, spec := range specs {
+		specs[i].Dir = spec.Path
+		if !IsBundle(spec.Path) {
+			if info, err := os.Stat(spec.Path); err == nil && info.Mode().IsRegular() {
+				cleanup()
+				return nil, func() {}, &errs.NotRepositoryError{Path: spec.Path, Err: errors.New("not a directory or a git bundle")}
+			}
+			continue
+		}
+		dir, remove, err := OpenBundle(spec.Path)
+		if err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+		cleanups = append(cleanups, remove)
+		specs[i].Dir = dir
+	}
+	scan.Repository = specs[0].Dir
+	return specs, cleanup, nil
+}
+
+func bundleLabel(path string) string {
+	return strings.TrimSuffix(path, ".bundle")
+}
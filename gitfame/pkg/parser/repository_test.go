@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+)
+
+// scan parses the command line args as the CLI does.
+func scan(tb testing.TB, args ...string) *scaner.Scaner {
+	tb.Helper()
+	s := &scaner.Scaner{}
+	require.NoError(tb, s.Parse(args))
+	return s
+}
+
+// collect runs the analysis of scan like the default command and returns
+// the lines of each author.
+func collect(tb testing.TB, s *scaner.Scaner) map[string]int {
+	tb.Helper()
+	specs, cleanup, err := OpenRepositories(s)
+	defer cleanup()
+	require.NoError(tb, err)
+	_, err = ApplyConfigs(s, specs)
+	require.NoError(tb, err)
+	require.NoError(tb, ValidateSpecs(s, specs))
+	stats, _, err := CollectStats(s, specs, nil, nil)
+	require.NoError(tb, err)
+	return authorLines(stats)
+}
+
+func authorLines(stats map[string]*AuthorStats) map[string]int {
+	lines := make(map[string]int)
+	for author, as := range stats {
+		if as.Name != "" {
+			author = as.Name
+		}
+		lines[author] = as.LinesCnt
+	}
+	return lines
+}
+
+func TestOpenRepositoriesBundleAndBare(t *testing.T) {
+	r := gittest.New(t)
+	r.Commit("Alice", map[string]string{"a.go": "package a\n\nfunc A() {}\n"})
+	r.Commit("Bob", map[string]string{"b.go": "package b\n"})
+	want := map[string]int{"Alice": 3, "Bob": 1}
+
+	bundle := filepath.Join(t.TempDir(), "repo.bundle")
+	r.Git("bundle", "create", bundle, "--all")
+	require.Equal(t, want, collect(t, scan(t, "--repository", bundle)))
+
+	bare := filepath.Join(t.TempDir(), "repo.git")
+	r.Git("clone", "-q", "--bare", r.Dir, bare)
+	require.Equal(t, want, collect(t, scan(t, "--repository", bare)))
+}
+
+func TestOpenRepositoriesNotBundle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(file, []byte("not a repository\n"), 0o644))
+	require.False(t, IsBundle(file))
+
+	_, cleanup, err := OpenRepositories(scan(t, "--repository", file))
+	defer cleanup()
+	var notRepo *errs.NotRepositoryError
+	require.ErrorAs(t, err, &notRepo)
+	require.Equal(t, errs.ExitNotRepository, errs.ExitCode(err))
+}
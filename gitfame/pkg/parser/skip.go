@@ -0,0 +1,291 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Reasons for leaving a file out of blame.
+const (
+	SkipBinary   = "binary"
+	SkipTooLarge = "too_large"
+	SkipLFS      = "lfs_pointer"
+)
+
+// Values of --lfs.
+const (
+	LFSSkip = "skip" // leave LFS pointers out
+	LFSFile = "file" // count a pointer as a file of its last author, without lines
+)
+
+// binaryPrefix is the number of leading bytes searched for a null byte, as git does.
+const binaryPrefix = 8000
+
+// lfsPointerMaxSize bounds the size of LFS pointer files, as in git-lfs.
+const lfsPointerMaxSize = 1024
+
+// SkippedFiles counts the files left out of blame by reason.
+type SkippedFiles map[string]int
+
+// Add adds the counts of other to s.
+func (s SkippedFiles) Add(other SkippedFiles) {
+	for reason, count := range other {
+		s[reason] += count
+	}
+}
+
+var sizeUnits = map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+
+// ParseSize parses a size like 300K, 10M or 1G; 0 means no limit.
+func ParseSize(flag, size string) (int64, error) {
+	upper := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B"), "I")
+	number := strings.TrimRight(upper, "KMG")
+	unit, ok := sizeUnits[upper[len(number):]]
+	value, err := strconv.ParseInt(number, 10, 64)
+	if !ok || err != nil || value < 0 {
+		return 0, errs.InvalidFlag(flag, "invalid size %q", size)
+	}
+	return value * unit, nil
+}
+
+// skipFiles drops files that are larger than --max-file-size, binary or
+// LFS pointers, counting them in p.Skipped. With --lfs=file LFS pointers are
+// kept and marked.
+func (p *Parser) skipFiles(files []TreeFile) ([]TreeFile, error) {
+	maxSize, err := ParseSize("max-file-size", p.Scaner.MaxFileSize)
+	if err != nil {
+		return nil, err
+	}
+	reasons := make([]string, len(files))
+	for i, file := range files {
+		if maxSize > 0 && file.Size > maxSize {
+			reasons[i] = SkipTooLarge
+		}
+	}
+	if err := classifyFiles(files, reasons, !p.Scaner.IncludeBinary); err != nil {
+		return nil, err
+	}
+	kept := make([]TreeFile, 0, len(files))
+	for i, file := range files {
+		if reasons[i] == SkipLFS && p.Scaner.LFS == LFSFile {
+			file.LFS = true
+			reasons[i] = ""
+		}
+		if reasons[i] != "" {
+			p.Skipped[reasons[i]]++
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept, nil
+}
+
+// classifyFiles sets the reason of LFS pointers and, if binary is set, of
+// binary files among the files that are not skipped yet.
+// A file is binary if its git attributes say so or, unless marked as text,
+// if it has a null byte in the first 8000 bytes.
+func classifyFiles(files []TreeFile, reasons []string, binary bool) error {
+	type source struct {
+		repo     *Repository
+		revision string
+		worktree bool
+	}
+	groups := make(map[source][]int)
+	var order []source
+	for i, file := range files {
+		if reasons[i] != "" {
+			continue
+		}
+		key := source{file.Repo, file.Revision, file.Worktree}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	for _, key := range order {
+		indices := groups[key]
+		paths := make([]string, len(indices))
+		for j, i := range indices {
+			paths[j] = files[i].Name
+		}
+		attrs, err := fileAttributes(key.repo, key.revision, key.worktree, paths)
+		if err != nil {
+			return err
+		}
+		classify := func(i, size int, prefix []byte) {
+			attr := attrs[files[i].Name]
+			switch {
+			case size <= lfsPointerMaxSize && isLFSPointer(prefix, attr.lfs):
+				reasons[i] = SkipLFS
+			case reasons[i] == "" && binary && !attr.text && bytes.IndexByte(prefix, 0) >= 0:
+				reasons[i] = SkipBinary
+			}
+		}
+		// contents are read when the null byte heuristic is needed or the file may be an LFS pointer
+		inspect := make(map[string][]int)
+		var hashes []string
+		for _, i := range indices {
+			attr := attrs[files[i].Name]
+			if binary && attr.binary {
+				reasons[i] = SkipBinary
+			}
+			heuristic := binary && !attr.binary && !attr.text
+			if !heuristic && files[i].Size > lfsPointerMaxSize {
+				continue
+			}
+			if key.worktree {
+				prefix, err := readFilePrefix(filepath.Join(key.repo.WorkTree, files[i].Name))
+				if err != nil {
+					return err
+				}
+				classify(i, int(files[i].Size), prefix)
+				continue
+			}
+			if _, ok := inspect[files[i].Hash]; !ok {
+				hashes = append(hashes, files[i].Hash)
+			}
+			inspect[files[i].Hash] = append(inspect[files[i].Hash], i)
+		}
+		err = readBlobs(key.repo, hashes, func(hash string, size int, prefix []byte) {
+			for _, i := range inspect[hash] {
+				classify(i, size, prefix)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isLFSPointer reports whether content is a git-lfs pointer. Files tracked
+// with filter=lfs are only required to have the version and oid lines.
+func isLFSPointer(content []byte, lfsAttr bool) bool {
+	text := string(content)
+	if !strings.HasPrefix(text, "version ") || !strings.Contains(text, "\noid ") {
+		return false
+	}
+	if lfsAttr {
+		return true
+	}
+	return strings.HasPrefix(text, "version https://git-lfs.github.com/spec/v1\n") &&
+		strings.Contains(text, "\noid sha256:") && strings.Contains(text, "\nsize ")
+}
+
+type attributes struct {
+	binary bool // binary, -diff or -text
+	text   bool // text is set
+	lfs    bool // filter=lfs
+}
+
+// fileAttributes reads the git attributes of paths that affect binary and
+// LFS detection. .gitattributes are read from revision through a temporary
+// index, so bare repositories work too, or from the work tree if worktree is set.
+func fileAttributes(repo *Repository, revision string, worktree bool, paths []string) (map[string]attributes, error) {
+	attrNames := []string{"binary", "diff", "text", "filter"}
+	var checkAttr *exec.Cmd
+	if worktree {
+		checkAttr = repo.WorkTreeCommand(append([]string{"check-attr", "-z", "--stdin"}, attrNames...)...)
+	} else {
+		dir, err := os.MkdirTemp("", "gitfame-index-")
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = os.RemoveAll(dir) }()
+		env := append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(dir, "index"))
+
+		readTree := repo.Command("read-tree", revision)
+		readTree.Env = env
+		if _, err := RunCmd(readTree); err != nil {
+			return nil, err
+		}
+		checkAttr = repo.Command(append([]string{"check-attr", "--cached", "-z", "--stdin"}, attrNames...)...)
+		checkAttr.Env = env
+	}
+	checkAttr.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	out, err := RunCmd(checkAttr)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]attributes)
+	fields := strings.Split(out, "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		path, attr, value := fields[i], fields[i+1], fields[i+2]
+		a := result[path]
+		switch {
+		case attr == "binary" && value == "set",
+			attr == "diff" && value == "unset",
+			attr == "text" && value == "unset":
+			a.binary = true
+		case attr == "text" && value == "set":
+			a.text = true
+		case attr == "filter" && value == "lfs":
+			a.lfs = true
+		}
+		result[path] = a
+	}
+	return result, nil
+}
+
+// readBlobs passes the size and the first 8000 bytes of each blob to visit.
+func readBlobs(repo *Repository, hashes []string, visit func(hash string, size int, prefix []byte)) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	cmd := repo.Command("cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(strings.Join(hashes, "\n") + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = readBatch(bufio.NewReaderSize(stdout, 1<<16), len(hashes), visit)
+	if err != nil {
+		_ = cmd.Process.Kill()
+	}
+	if waitErr := cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return &errs.GitError{Args: cmd.Args, Stderr: stderr.String(), Err: err}
+	}
+	return nil
+}
+
+// readBatch reads n blobs printed by git cat-file --batch.
+func readBatch(out *bufio.Reader, n int, visit func(hash string, size int, prefix []byte)) error {
+	for i := 0; i < n; i++ {
+		header, err := out.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return fmt.Errorf("unexpected cat-file output: %q", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+		prefix, err := out.Peek(min(size, binaryPrefix))
+		if err != nil {
+			return err
+		}
+		visit(fields[0], size, prefix)
+		if _, err := out.Discard(size + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
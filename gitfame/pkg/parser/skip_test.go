@@ -0,0 +1,20 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+)
+
+func TestMaxFileSizeDefault(t *testing.T) {
+	r := gittest.New(t)
+	// 1.5 MiB of text
+	r.Commit("Alice", map[string]string{"big.txt": strings.Repeat(strings.Repeat("x", 99)+"\n", 15_000)})
+	r.Commit("Bob", map[string]string{"small.txt": "small\n"})
+
+	require.Equal(t, map[string]int{"Alice": 15_000, "Bob": 1}, collect(t, scan(t, "--repository", r.Dir)))
+	require.Equal(t, map[string]int{"Bob": 1}, collect(t, scan(t, "--repository", r.Dir, "--max-file-size", "1M")))
+}
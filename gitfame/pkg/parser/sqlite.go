@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"database/sql"
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"io"
+	_ "modernc.org/sqlite" // pure Go driver "sqlite"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sqliteSchema creates the tables of --format=sqlite. Every row belongs to a
+// run: the analysis of a repository at a commit with the same options.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id            INTEGER PRIMARY KEY,
+	repository    TEXT NOT NULL,
+	revision      TEXT NOT NULL, -- commit hash
+	revision_name TEXT NOT NULL, -- --revision as given
+	options       TEXT NOT NULL,
+	created_at    TEXT NOT NULL,
+	UNIQUE (repository, revision, options)
+);
+CREATE TABLE IF NOT EXISTS authors (
+	id           INTEGER PRIMARY KEY,
+	run_id       INTEGER NOT NULL REFERENCES runs (id),
+	name         TEXT NOT NULL,
+	repo         TEXT NOT NULL,
+	email        TEXT NOT NULL,
+	lines        INTEGER NOT NULL,
+	commits      INTEGER NOT NULL,
+	files        INTEGER NOT NULL,
+	first_commit TEXT,
+	last_commit  TEXT,
+	UNIQUE (run_id, repo, name)
+);
+CREATE TABLE IF NOT EXISTS commits (
+	run_id       INTEGER NOT NULL REFERENCES runs (id),
+	hash         TEXT NOT NULL,
+	author_id    INTEGER REFERENCES authors (id), -- row of the author the commit is counted for
+	author       TEXT NOT NULL,
+	author_email TEXT NOT NULL,
+	author_time  TEXT,
+	committer    TEXT NOT NULL,
+	PRIMARY KEY (run_id, hash)
+);
+CREATE TABLE IF NOT EXISTS files (
+	run_id   INTEGER NOT NULL REFERENCES runs (id),
+	path     TEXT NOT NULL,
+	language TEXT,
+	lines    INTEGER NOT NULL,
+	PRIMARY KEY (run_id, path)
+);
+CREATE TABLE IF NOT EXISTS blame_hunks (
+	run_id      INTEGER NOT NULL REFERENCES runs (id),
+	path        TEXT NOT NULL,
+	start_line  INTEGER NOT NULL,
+	lines       INTEGER NOT NULL,
+	commit_hash TEXT NOT NULL,
+	PRIMARY KEY (run_id, path, start_line)
+);
+`
+
+// runTables are cleared when a run is repeated, in the order of references.
+var runTables = []string{"blame_hunks", "files", "commits", "authors"}
+
+// SQLiteFormatter writes the stats and the blame hunks of a run to the
+// database file --output. Open must be called before the analysis.
+type SQLiteFormatter struct {
+	FormatOptions
+	Path    string // database file
+	Options string // analysis options identifying the run with the revision
+
+	db    *sql.DB
+	tx    *sql.Tx
+	runID int64
+}
+
+func NewSQLiteFormatter(scan *scaner.Scaner, opts FormatOptions) (*SQLiteFormatter, error) {
+	if scan.Output == "" {
+		return nil, errs.InvalidFlag("output", "required with --format=sqlite")
+	}
+	return &SQLiteFormatter{
+		FormatOptions: opts,
+		Path:          scan.Output,
+		Options:       fmt.Sprintf("%s by=%q", AnalysisOptions(scan), scan.By),
+	}, nil
+}
+
+// sqlTime formats t for a TEXT column, NULL if t is zero.
+func sqlTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Open starts the run of the single repository in specs. A run of the same
+// repository, commit and options is replaced instead of duplicated.
+func (sf *SQLiteFormatter) Open(scan *scaner.Scaner, specs []RepoSpec) error {
+	if len(specs) != 1 {
+		return errs.InvalidFlag("format", "sqlite output analyzes a single repository")
+	}
+	spec := specs[0]
+	repo, err := ResolveRepository(spec.Dir)
+	if err != nil {
+		return err
+	}
+	revisionName := spec.Scaner(scan).Revision
+	revision, err := repo.ResolveRevision(revisionName)
+	if err != nil {
+		return &errs.UnknownRevisionError{Repository: spec.Path, Revision: revisionName}
+	}
+	repository, err := filepath.Abs(spec.Path)
+	if err != nil {
+		return err
+	}
+
+	if sf.db, err = sql.Open("sqlite", sf.Path); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	if _, err := sf.db.Exec(sqliteSchema); err != nil {
+		return &errs.OutputError{Err: fmt.Errorf("%s: %w", sf.Path, err)}
+	}
+	if sf.tx, err = sf.db.Begin(); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	err = sf.tx.QueryRow(`INSERT INTO runs (repository, revision, revision_name, options, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (repository, revision, options)
+		DO UPDATE SET revision_name = excluded.revision_name, created_at = excluded.created_at
+		RETURNING id`,
+		repository, revision, revisionName, sf.Options, sqlTime(time.Now())).Scan(&sf.runID)
+	if err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	for _, table := range runTables {
+		if _, err := sf.tx.Exec("DELETE FROM "+table+" WHERE run_id = ?", sf.runID); err != nil {
+			return &errs.OutputError{Err: err}
+		}
+	}
+	return nil
+}
+
+// Hunks inserts each hunk together with its commit and file; w is unused.
+func (sf *SQLiteFormatter) Hunks(w io.Writer) func(BlameHunk) error {
+	return func(hunk BlameHunk) error {
+		_, err := sf.tx.Exec(`INSERT OR REPLACE INTO blame_hunks (run_id, path, start_line, lines, commit_hash)
+			VALUES (?, ?, ?, ?, ?)`, sf.runID, hunk.Path, hunk.StartLine, hunk.Lines, hunk.Commit)
+		if err == nil {
+			_, err = sf.tx.Exec(`INSERT INTO commits (run_id, hash, author, author_email, author_time, committer)
+				VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (run_id, hash) DO NOTHING`,
+				sf.runID, hunk.Commit, hunk.Author, hunk.AuthorEmail, sqlTime(hunk.AuthorTime), hunk.Committer)
+		}
+		if err == nil {
+			_, err = sf.tx.Exec(`INSERT INTO files (run_id, path, language, lines) VALUES (?, ?, ?, ?)
+				ON CONFLICT (run_id, path) DO UPDATE SET lines = lines + excluded.lines`,
+				sf.runID, hunk.Path, hunk.Language, hunk.Lines)
+		}
+		if err != nil {
+			return &errs.OutputError{Err: err}
+		}
+		return nil
+	}
+}
+
+// Output inserts the authors, links the commits to them and commits the run;
+// nothing is written to w. Authors are not filtered by --top or --min-*.
+func (sf *SQLiteFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	for author, stats := range statsMap {
+		if stats.Name != "" {
+			author = stats.Name
+		}
+		var authorID int64
+		err := sf.tx.QueryRow(`INSERT INTO authors (run_id, name, repo, email, lines, commits, files, first_commit, last_commit)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			sf.runID, author, stats.Repo, strings.Join(sortedEmails(stats), ","), stats.LinesCnt,
+			stats.Commits.Len(), stats.Files.Len(), sqlTime(stats.FirstCommit), sqlTime(stats.LastCommit)).Scan(&authorID)
+		if err != nil {
+			return &errs.OutputError{Err: err}
+		}
+		for _, commit := range stats.Commits.Strings() {
+			_, err := sf.tx.Exec(`INSERT INTO commits (run_id, hash, author_id, author, author_email, committer)
+				VALUES (?, ?, ?, '', '', '') ON CONFLICT (run_id, hash) DO UPDATE SET author_id = excluded.author_id`,
+				sf.runID, commit, authorID)
+			if err != nil {
+				return &errs.OutputError{Err: err}
+			}
+		}
+	}
+	if err := sf.tx.Commit(); err != nil {
+		return &errs.OutputError{Err: err}
+	}
+	sf.tx = nil
+	return nil
+}
+
+// Close rolls back a run that wasn't output and closes the database.
+func (sf *SQLiteFormatter) Close() error {
+	if sf.db == nil {
+		return nil
+	}
+	if sf.tx != nil {
+		_ = sf.tx.Rollback()
+	}
+	return sf.db.Close()
+}
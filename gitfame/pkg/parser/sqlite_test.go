@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"database/sql"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+)
+
+// writeSQLite runs --format=sqlite on the repository into the database db
+// the way the default command does.
+func writeSQLite(t *testing.T, dir, db string, args ...string) {
+	t.Helper()
+	s := scan(t, append([]string{"--repository", dir, "--format", "sqlite", "--output", db}, args...)...)
+	formatter, err := NewFormatter(s)
+	require.NoError(t, err)
+	sf := formatter.(*SQLiteFormatter)
+	defer sf.Close()
+
+	specs, cleanup, err := OpenRepositories(s)
+	defer cleanup()
+	require.NoError(t, err)
+	require.NoError(t, ValidateSpecs(s, specs))
+	require.NoError(t, sf.Open(s, specs))
+	stats, _, err := CollectStats(s, specs, nil, sf.Hunks(io.Discard))
+	require.NoError(t, err)
+	require.NoError(t, sf.Output(io.Discard, stats))
+}
+
+// rowCounts returns the number of rows in every table of the database.
+func rowCounts(t *testing.T, path string) map[string]int {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	counts := make(map[string]int)
+	for _, table := range append([]string{"runs"}, runTables...) {
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&n))
+		counts[table] = n
+	}
+	return counts
+}
+
+func TestSQLiteRunReplaced(t *testing.T) {
+	r := gittest.New(t)
+	r.Commit("Alice", map[string]string{"a.go": "package a\n\nfunc A() {}\n", "README.md": "a\n"})
+	r.Commit("Bob", map[string]string{"a.go": "package a\n\nfunc A() {}\n\nfunc B() {}\n", "b.go": "package b\n"})
+	db := filepath.Join(t.TempDir(), "fame.db")
+
+	// a.go has a hunk by Alice and a hunk by Bob
+	want := map[string]int{"runs": 1, "authors": 2, "commits": 2, "files": 3, "blame_hunks": 4}
+	writeSQLite(t, r.Dir, db)
+	require.Equal(t, want, rowCounts(t, db))
+	writeSQLite(t, r.Dir, db)
+	require.Equal(t, want, rowCounts(t, db))
+
+	// other options are another run
+	writeSQLite(t, r.Dir, db, "--extensions", ".go")
+	require.Equal(t, map[string]int{"runs": 2, "authors": 4, "commits": 4, "files": 5, "blame_hunks": 7}, rowCounts(t, db))
+}
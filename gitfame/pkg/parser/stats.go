@@ -1,123 +1,317 @@
 package parser
 
 import (
-	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"math"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 )
 
 type StatsAuthor struct {
-	Name    string `json:"name"`
-	Lines   int    `json:"lines"`
-	Commits int    `json:"commits"`
-	Files   int    `json:"files"`
-}
-type SortByCriteria struct {
-	summaries []StatsAuthor
-}
+	Name        string    `json:"name"`
+	Repo        string    `json:"repo"`
+	Email       string    `json:"email"`
+	Lines       int       `json:"lines"`
+	Commits     int       `json:"commits"`
+	Files       int       `json:"files"`
+	FirstCommit time.Time `json:"first_commit"`
+	LastCommit  time.Time `json:"last_commit"`
 
-func NewSortByCriteria(summaries []StatsAuthor) *SortByCriteria {
-	return &SortByCriteria{summaries: summaries}
+	Ages map[int]int `json:"-"` // age of lines in days -> number of lines
 }
 
-func (s *SortByCriteria) Lines(i, j int) int {
-	return compareInt(s.summaries, func(a StatsAuthor) int { return a.Lines })(i, j)
+// SortKey is a single --order-by key.
+type SortKey struct {
+	Column Column
+	Desc   bool
 }
 
-func (s *SortByCriteria) Commits(i, j int) int {
-	return compareInt(s.summaries, func(a StatsAuthor) int { return a.Commits })(i, j)
-}
+// defaultOrder is appended to every --order-by to break ties.
+var defaultOrder = []string{"lines", "commits", "files", "name", "repo"}
 
-func (s *SortByCriteria) Files(i, j int) int {
-	return compareInt(s.summaries, func(a StatsAuthor) int { return a.Files })(i, j)
+// ParseOrderBy parses a comma separated list of column keys.
+// A key may be prefixed with '+' for ascending or '-' for descending order;
+// without a prefix numeric columns are sorted descending and the rest ascending.
+// ageColumns are the columns of the --age-buckets buckets, as in ParseColumns.
+func ParseOrderBy(orderBy string, ageColumns []Column) ([]SortKey, error) {
+	var keys []SortKey
+	seen := make(map[string]bool)
+	add := func(key string) error {
+		desc, explicit := false, false
+		switch {
+		case strings.HasPrefix(key, "-"):
+			desc, explicit = true, true
+		case strings.HasPrefix(key, "+"):
+			explicit = true
+		}
+		if explicit {
+			key = key[1:]
+		}
+		col, ok := lookupColumn(key, ageColumns)
+		if !ok {
+			return errs.InvalidFlag("order-by", "unknown key %q", key)
+		}
+		if seen[col.Key] {
+			return nil
+		}
+		seen[col.Key] = true
+		if !explicit {
+			desc = col.IsNumeric()
+		}
+		keys = append(keys, SortKey{Column: col, Desc: desc})
+		return nil
+	}
+	for _, key := range SplitByDot(orderBy) {
+		if err := add(strings.ToLower(strings.TrimSpace(key))); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errs.InvalidFlag("order-by", "no sort keys in %q", orderBy)
+	}
+	for _, key := range defaultOrder {
+		if err := add(key); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
 }
 
-func (s *SortByCriteria) Name(i, j int) int {
-	return compareStr(s.summaries, func(a StatsAuthor) string { return a.Name })(i, j)
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		return compareOrdered(a, b.(int))
+	case float64:
+		return compareOrdered(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
 }
 
-func getSortFunction(sortByCriteria *SortByCriteria, sortOrder []string) func(i, j int) bool {
-	var sortFunctions []func(i, j int) int
-	for _, criteria := range sortOrder {
-		switch criteria {
-		case "Lines":
-			sortFunctions = append(sortFunctions, sortByCriteria.Lines)
-		case "Commits":
-			sortFunctions = append(sortFunctions, sortByCriteria.Commits)
-		case "Files":
-			sortFunctions = append(sortFunctions, sortByCriteria.Files)
-		case "Name":
-			sortFunctions = append(sortFunctions, sortByCriteria.Name)
-		}
+func compareOrdered[T int | float64](a, b T) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
 	}
-	return func(i, j int) bool {
-		for _, sortFunc := range sortFunctions {
-			result := sortFunc(i, j)
+	return 0
+}
+
+func SortRows(rows []StatsShare, order []SortKey) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, key := range order {
+			result := compareValues(key.Column.Value(rows[i]), key.Column.Value(rows[j]))
+			if key.Desc {
+				result = -result
+			}
 			if result != 0 {
-				return result > 0
+				return result < 0
 			}
 		}
 		return false
-	}
+	})
 }
 
-func compareInt(summaries []StatsAuthor, getValue func(StatsAuthor) int) func(int, int) int {
-	return func(i, j int) int {
-		a, b := getValue(summaries[i]), getValue(summaries[j])
-		if a == b {
-			return 0
-		} else if a > b {
-			return 1
+func GetStats(statsMap map[string]*AuthorStats, order []SortKey) []StatsShare {
+	totals := GetTotals(statsMap)
+	var summaries []StatsShare
+	for author, stats := range statsMap {
+		emails := make([]string, 0, len(stats.Emails))
+		for email := range stats.Emails {
+			emails = append(emails, email)
 		}
-		return -1
+		sort.Strings(emails)
+		if stats.Name != "" {
+			author = stats.Name
+		}
+		summary := StatsAuthor{
+			Name:        author,
+			Repo:        stats.Repo,
+			Email:       strings.Join(emails, ","),
+			Lines:       stats.LinesCnt,
+			Commits:     stats.Commits.Len(),
+			Files:       stats.Files.Len(),
+			FirstCommit: stats.FirstCommit,
+			LastCommit:  stats.LastCommit,
+			Ages:        stats.Ages,
+		}
+		summaries = append(summaries, NewStatsShare(summary, totals))
+	}
+	SortRows(summaries, order)
+	return summaries
+}
+
+// FormatOptions are the settings shared by all formatters.
+type FormatOptions struct {
+	SortOrder     []SortKey
+	Columns       []Column
+	Totals        bool // append a summary row
+	ReportSkipped bool // print the skipped files in json
+	Top           int  // print at most Top authors, 0 means no limit
+	MinLines      int
+	MinCommits    int
+	Skipped       SkippedFiles // set after the analysis
+}
+
+func (o *FormatOptions) SetSkipped(skipped SkippedFiles) {
+	o.Skipped = skipped
+}
+
+// StatsShare is an author summary extended with shares of the repo totals.
+type StatsShare struct {
+	StatsAuthor
+	LinesShare   float64
+	CommitsShare float64
+	FilesShare   float64
+	IsTotal      bool // the --totals row, which may share its name with an author
+}
+
+func share(part, total int) float64 {
+	if total == 0 {
+		return 0
 	}
+	return math.Round(1000*float64(part)/float64(total)) / 10
 }
 
-func compareStr(summaries []StatsAuthor, getValue func(StatsAuthor) string) func(int, int) int {
-	return func(i, j int) int {
-		return -strings.Compare(getValue(summaries[i]), getValue(summaries[j]))
+func NewStatsShare(author StatsAuthor, totals Totals) StatsShare {
+	return StatsShare{
+		StatsAuthor:  author,
+		LinesShare:   share(author.Lines, totals.Lines),
+		CommitsShare: share(author.Commits, totals.Commits),
+		FilesShare:   share(author.Files, totals.Files),
 	}
 }
 
-func GetStats(statsMap map[string]*AuthorStats, sortOrder []string) []StatsAuthor {
-	var summaries []StatsAuthor
-	for author, stats := range statsMap {
-		summary := StatsAuthor{
-			Name:    author,
-			Lines:   stats.LinesCnt,
-			Commits: len(stats.Commits),
-			Files:   len(stats.Files),
+// Rows returns sorted author rows passing the --min-* thresholds, truncated to --top.
+func (o *FormatOptions) Rows(statsMap map[string]*AuthorStats) []StatsShare {
+	rows := make([]StatsShare, 0, len(statsMap))
+	for _, row := range GetStats(statsMap, o.SortOrder) {
+		if row.Lines < o.MinLines || row.Commits < o.MinCommits {
+			continue
 		}
-		summaries = append(summaries, summary)
+		rows = append(rows, row)
 	}
-	sortByCriteria := NewSortByCriteria(summaries)
-	sort.Slice(summaries, getSortFunction(sortByCriteria, sortOrder))
-	return summaries
+	if o.Top > 0 && len(rows) > o.Top {
+		rows = rows[:o.Top]
+	}
+	return rows
+}
+
+// TotalRow returns the summary row printed with --totals.
+func (o *FormatOptions) TotalRow(statsMap map[string]*AuthorStats) StatsShare {
+	totals := GetTotals(statsMap)
+	total := StatsAuthor{
+		Name:    "Total",
+		Lines:   totals.Lines,
+		Commits: totals.Commits,
+		Files:   totals.Files,
+		Ages:    make(map[int]int),
+	}
+	for _, stats := range statsMap {
+		for days, lines := range stats.Ages {
+			total.Ages[days] += lines
+		}
+		if total.FirstCommit.IsZero() || stats.FirstCommit.Before(total.FirstCommit) {
+			total.FirstCommit = stats.FirstCommit
+		}
+		if stats.LastCommit.After(total.LastCommit) {
+			total.LastCommit = stats.LastCommit
+		}
+	}
+	row := NewStatsShare(total, totals)
+	row.IsTotal = true
+	return row
+}
+
+// TableRows returns the rows printed by the table-like formatters.
+func (o *FormatOptions) TableRows(statsMap map[string]*AuthorStats) []StatsShare {
+	rows := o.Rows(statsMap)
+	if o.Totals {
+		rows = append(rows, o.TotalRow(statsMap))
+	}
+	return rows
 }
 
-func NewFormatter(format string, orderBy string) (Formatter, error) {
-	var sortOrder []string
-	switch orderBy {
-	case "lines":
-		sortOrder = []string{"Lines", "Commits", "Files", "Name"}
-	case "commits":
-		sortOrder = []string{"Commits", "Lines", "Files", "Name"}
-	case "files":
-		sortOrder = []string{"Files", "Lines", "Commits", "Name"}
-	default:
-		return nil, fmt.Errorf("invalid order")
+func NewFormatOptions(scan *scaner.Scaner) (FormatOptions, error) {
+	ageColumns, err := AgeColumns(scan.AgeBuckets)
+	if err != nil {
+		return FormatOptions{}, err
+	}
+	sortOrder, err := ParseOrderBy(scan.OrderBy, ageColumns)
+	if err != nil {
+		return FormatOptions{}, err
+	}
+	if !slices.Contains(Groupings, scan.By) {
+		return FormatOptions{}, errs.InvalidFlag("by", "unknown grouping %q", scan.By)
+	}
+	columns, err := ParseColumns(scan.Columns, scan.Percentages, scan.Ages, scan.By, ageColumns)
+	if err != nil {
+		return FormatOptions{}, err
+	}
+	if scan.Top < 0 || scan.MinLines < 0 || scan.MinCommits < 0 {
+		return FormatOptions{}, errs.InvalidFlag("", "--top, --min-lines and --min-commits must not be negative")
+	}
+	return FormatOptions{
+		SortOrder:     sortOrder,
+		Columns:       columns,
+		Totals:        scan.Totals,
+		ReportSkipped: scan.ReportSkipped,
+		Top:           scan.Top,
+		MinLines:      scan.MinLines,
+		MinCommits:    scan.MinCommits,
+	}, nil
+}
+
+// Formats lists the values of --format.
+var Formats = []string{"tabular", "csv", "json", "json-lines", "markdown", "html", "template", "blame-jsonl", "sqlite"}
+
+func NewFormatter(scan *scaner.Scaner) (Formatter, error) {
+	format := scan.Format
+	opts, err := NewFormatOptions(scan)
+	if err != nil {
+		return nil, err
+	}
+	if scan.Output != "" && format != "sqlite" {
+		return nil, errs.InvalidFlag("output", "only used with --format=sqlite")
+	}
+	if scan.ReportSkipped && format != "json" {
+		return nil, errs.InvalidFlag("report-skipped", "only used with --format=json")
+	}
+	if scan.Totals && format == "json-lines" {
+		return nil, errs.InvalidFlag("totals", "--format=json-lines prints only author records, use --format=json")
 	}
 	if format == "tabular" {
-		return &TabularFormatter{SortOrder: sortOrder}, nil
+		return &TabularFormatter{FormatOptions: opts}, nil
 	}
 	if format == "csv" {
-		return &CSVFormatter{SortOrder: sortOrder}, nil
+		return &CSVFormatter{FormatOptions: opts}, nil
 	}
 	if format == "json" {
-		return &JSONFormatter{SortOrder: sortOrder}, nil
+		return &JSONFormatter{FormatOptions: opts}, nil
 	}
 	if format == "json-lines" {
-		return &JSONLinesFormatter{SortOrder: sortOrder}, nil
+		return &JSONLinesFormatter{FormatOptions: opts}, nil
+	}
+	if format == "markdown" {
+		return &MarkdownFormatter{FormatOptions: opts}, nil
+	}
+	if format == "html" {
+		return &HTMLFormatter{FormatOptions: opts}, nil
+	}
+	if format == "template" {
+		return NewTemplateFormatter(scan, opts)
+	}
+	if format == "blame-jsonl" {
+		return &BlameJSONLFormatter{FormatOptions: opts}, nil
+	}
+	if format == "sqlite" {
+		return NewSQLiteFormatter(scan, opts)
 	}
-	return nil, fmt.Errorf("invalid format")
+	return nil, errs.InvalidFlag("format", "unknown format %q", format)
 }
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// authorNamedTotal returns stats with a real author named like the totals row.
+func authorNamedTotal() map[string]*AuthorStats {
+	stats := make(map[string]*AuthorStats)
+	for name, lines := range map[string]int{"Total": 3, "Alice": 1} {
+		as := NewAuthorStats()
+		as.LinesCnt = lines
+		as.Commits.Add(name + " commit")
+		as.Files.Add(name + ".go")
+		stats[name] = as
+	}
+	return stats
+}
+
+func TestTableRowsMarkTotal(t *testing.T) {
+	opts, err := NewFormatOptions(scan(t, "--totals"))
+	require.NoError(t, err)
+	rows := opts.TableRows(authorNamedTotal())
+	require.Len(t, rows, 3)
+	require.Equal(t, "Total", rows[0].Name)
+	require.False(t, rows[0].IsTotal)
+	require.Equal(t, "Total", rows[2].Name)
+	require.True(t, rows[2].IsTotal)
+	require.Equal(t, 4, rows[2].Lines)
+}
+
+func TestHTMLTotalInFooter(t *testing.T) {
+	formatter, err := NewFormatter(scan(t, "--format", "html", "--totals"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, formatter.Output(&out, authorNamedTotal()))
+	body, footer, ok := strings.Cut(out.String(), "<tfoot>")
+	require.True(t, ok)
+	require.Contains(t, body, "<td>Total</td><td class=\"num\">3</td>")
+	require.Contains(t, footer, "<td>Total</td><td class=\"num\">4</td>")
+}
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Unassigned is the team of authors that match no team.
+const Unassigned = "unassigned"
+
+// validateTeams checks that every team has members and that the member
+// patterns are valid globs.
+func validateTeams(flag, name string, teams map[string][]string) error {
+	for team, members := range teams {
+		if team == "" || len(members) == 0 {
+			return errs.InvalidFlag(flag, "%s: team %q has no members", name, team)
+		}
+		for _, member := range members {
+			if _, err := path.Match(member, ""); err != nil {
+				return errs.InvalidFlag(flag, "%s: team %q: invalid pattern %q", name, team, member)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadTeams reads a --teams file mapping teams to the names and emails of
+// their members, e.g. "core: [Joe Tsai, '*@google.com']".
+func LoadTeams(name string) (map[string][]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errs.InvalidFlag("teams", "%w", err)
+	}
+	var teams map[string][]string
+	if err := yaml.UnmarshalStrict(data, &teams); err != nil {
+		return nil, errs.InvalidFlag("teams", "%s: %w", name, err)
+	}
+	if err := validateTeams("teams", name, teams); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// isMember reports whether a lowercased identity matches a member pattern;
+// patterns are case-insensitive globs.
+func isMember(pattern, id string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), id)
+	return ok
+}
+
+// TeamOf returns the team of an author matched by name or email, or
+// Unassigned. An author matching several teams belongs to the first one by name.
+func TeamOf(author string, stats map[string]*AuthorStats, teams map[string][]string) string {
+	names := make([]string, 0, len(teams))
+	for team := range teams {
+		names = append(names, team)
+	}
+	sort.Strings(names)
+	ids := identities(author, stats)
+	for _, team := range names {
+		for _, member := range teams[team] {
+			for _, id := range ids {
+				if isMember(member, id) {
+					return team
+				}
+			}
+		}
+	}
+	return Unassigned
+}
+
+// GroupTeams merges the stats of authors into their teams. Commits and files
+// shared by members of a team are counted once.
+func GroupTeams(stats map[string]*AuthorStats, teams map[string][]string) map[string]*AuthorStats {
+	grouped := make(map[string]*AuthorStats)
+	for author, as := range stats {
+		team := TeamOf(author, stats, teams)
+		if _, ok := grouped[team]; !ok {
+			grouped[team] = NewAuthorStats()
+		}
+		grouped[team].Merge(as, "")
+	}
+	return grouped
+}
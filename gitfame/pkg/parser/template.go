@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is the value passed to templates used with --format=template.
+type TemplateData struct {
+	Authors      []StatsShare // sorted according to --order-by
+	Totals       Totals       // unique lines, commits and files over all authors
+	Revision     string       // revision as passed in --revision
+	RevisionHash string       // full hash of the analyzed commit; empty for several repositories
+	CommitTime   time.Time    // committer date of the analyzed commit; zero for several repositories
+	GeneratedAt  time.Time    // time the report was produced
+	Filters      Filters
+	Repositories []TemplateRepository // analyzed repositories in the order they were given
+}
+
+// TemplateRepository is the analyzed commit of one repository.
+type TemplateRepository struct {
+	Name         string
+	Revision     string
+	RevisionHash string
+	CommitTime   time.Time
+}
+
+type Totals struct {
+	Lines   int `json:"lines"`
+	Commits int `json:"commits"`
+	Files   int `json:"files"`
+}
+
+type Filters struct {
+	Extensions []string
+	Languages  []string
+	Exclude    []string
+	RestrictTo []string
+}
+
+var templateFuncs = template.FuncMap{
+	"pad":     func(width int, s string) string { return fmt.Sprintf("%-*s", width, s) },
+	"padLeft": func(width int, s string) string { return fmt.Sprintf("%*s", width, s) },
+	"percent": func(part, total int) string {
+		if total == 0 {
+			return "0.0"
+		}
+		return fmt.Sprintf("%.1f", 100*float64(part)/float64(total))
+	},
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+type TemplateFormatter struct {
+	FormatOptions
+	Scaner   *scaner.Scaner
+	Template *template.Template
+
+	repositories []TemplateRepository
+}
+
+func NewTemplateFormatter(scan *scaner.Scaner, opts FormatOptions) (*TemplateFormatter, error) {
+	text := scan.Template
+	if scan.TemplateFile != "" {
+		if text != "" {
+			return nil, errs.InvalidFlag("template", "--template and --template-file are mutually exclusive")
+		}
+		data, err := os.ReadFile(scan.TemplateFile)
+		if err != nil {
+			return nil, errs.InvalidFlag("template-file", "%w", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return nil, errs.InvalidFlag("template", "--format=template requires --template or --template-file")
+	}
+	tmpl, err := template.New("gitfame").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, errs.InvalidFlag("template", "%w", err)
+	}
+	if err := tmpl.Execute(io.Discard, emptyTemplateData()); err != nil {
+		return nil, errs.InvalidFlag("template", "%w", err)
+	}
+	return &TemplateFormatter{FormatOptions: opts, Scaner: scan, Template: tmpl}, nil
+}
+
+// emptyTemplateData is the data a template is checked against before the
+// analysis: no lines, but an author, a repository and a full hash, so that
+// templates indexing the first row or slicing the hash pass.
+func emptyTemplateData() TemplateData {
+	hash := strings.Repeat("0", 40)
+	return TemplateData{
+		Authors:      make([]StatsShare, 1),
+		Revision:     "HEAD",
+		RevisionHash: hash,
+		Repositories: []TemplateRepository{{Revision: "HEAD", RevisionHash: hash}},
+	}
+}
+
+func GetTotals(statsMap map[string]*AuthorStats) Totals {
+	var totals Totals
+	var commits, files IDSet
+	for _, stats := range statsMap {
+		totals.Lines += stats.LinesCnt
+		commits.Union(&stats.Commits, "")
+		files.Union(&stats.Files, "")
+	}
+	totals.Commits = commits.Len()
+	totals.Files = files.Len()
+	return totals
+}
+
+// Open resolves the analyzed commit of every repository; it is called once the
+// repositories are opened, before Output.
+func (tf *TemplateFormatter) Open(scan *scaner.Scaner, specs []RepoSpec) error {
+	tf.repositories = nil
+	for _, spec := range specs {
+		specScan := spec.Scaner(scan)
+		repo, err := ResolveRepository(specScan.Repository)
+		if err != nil {
+			return err
+		}
+		revision, err := repo.ResolveRevision(specScan.Revision)
+		if err != nil {
+			return &errs.UnknownRevisionError{Repository: spec.Path, Revision: specScan.Revision}
+		}
+		out, err := repo.Git("log", "-1", "--format=%H %cI", revision)
+		if err != nil {
+			return err
+		}
+		hash, date, _ := strings.Cut(out, " ")
+		commitTime, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return err
+		}
+		tf.repositories = append(tf.repositories, TemplateRepository{
+			Name:         spec.Label(),
+			Revision:     specScan.Revision,
+			RevisionHash: hash,
+			CommitTime:   commitTime,
+		})
+	}
+	return nil
+}
+
+func (tf *TemplateFormatter) Output(w io.Writer, statsMap map[string]*AuthorStats) error {
+	data := TemplateData{
+		Authors:     tf.Rows(statsMap),
+		Totals:      GetTotals(statsMap),
+		Revision:    tf.Scaner.Revision,
+		GeneratedAt: time.Now(),
+		Filters: Filters{
+			Extensions: SplitByDot(tf.Scaner.Extensions),
+			Languages:  SplitByDot(tf.Scaner.Languages),
+			Exclude:    SplitByDot(tf.Scaner.Exclude),
+			RestrictTo: SplitByDot(tf.Scaner.RestrictTo),
+		},
+	}
+	data.Repositories = tf.repositories
+	if len(tf.repositories) == 1 {
+		repo := tf.repositories[0]
+		data.Revision, data.RevisionHash, data.CommitTime = repo.Revision, repo.RevisionHash, repo.CommitTime
+	}
+
+	var buf bytes.Buffer
+	if err := tf.Template.Execute(&buf, data); err != nil {
+		return errs.InvalidFlag("template", "%w", err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+)
+
+// ValidateFilters checks the file filters of scan before any repository is read.
+func ValidateFilters(scan *scaner.Scaner) error {
+	if _, err := CompilePatterns("exclude", scan.Exclude); err != nil {
+		return err
+	}
+	if _, err := CompilePatterns("restrict-to", scan.RestrictTo); err != nil {
+		return err
+	}
+	if _, err := ParseSize("max-file-size", scan.MaxFileSize); err != nil {
+		return err
+	}
+	if scan.LFS != LFSSkip && scan.LFS != LFSFile {
+		return errs.InvalidFlag("lfs", "unknown mode %q", scan.LFS)
+	}
+	if scan.Worktree && scan.Revision != "HEAD" {
+		return errs.InvalidFlag("worktree", "the working tree is compared with HEAD, --revision %q can't be used", scan.Revision)
+	}
+	if scan.Worktree && scan.RecurseSubmodules {
+		return errs.InvalidFlag("worktree", "can't be combined with --recurse-submodules")
+	}
+	if scan.IgnoreFilters && scan.FilesFrom == "" {
+		return errs.InvalidFlag("ignore-filters", "requires --files-from")
+	}
+	if scan.IncludeUntracked && !scan.Worktree {
+		return errs.InvalidFlag("include-untracked", "requires --worktree")
+	}
+	if scan.WorktreeAuthor != "" && !scan.Worktree {
+		return errs.InvalidFlag("worktree-author", "requires --worktree")
+	}
+	return nil
+}
+
+// ValidateSpecs checks the file filters of every repository.
+// Unknown languages are only reported, once: they are ignored by the filter.
+func ValidateSpecs(scan *scaner.Scaner, specs []RepoSpec) error {
+	if scan.FilesFrom != "" && len(specs) > 1 {
+		return errs.InvalidFlag("files-from", "can't be used with several repositories")
+	}
+	reported := make(map[string]bool)
+	for _, spec := range specs {
+		specScan := spec.Scaner(scan)
+		if err := ValidateFilters(specScan); err != nil {
+			return fmt.Errorf("repository %s: %w", spec.Path, err)
+		}
+		unknown, err := UnknownLanguages(specScan.Languages)
+		if err != nil {
+			return err
+		}
+		for _, lang := range unknown {
+			if !reported[lang] {
+				reported[lang] = true
+				logrus.Warnf("unknown language %q in --languages", lang)
+			}
+		}
+	}
+	return nil
+}
+
+// AnalysisOptions returns the options that affect collected stats;
+// formatting options can be applied later on top of the same stats.
+func AnalysisOptions(scan *scaner.Scaner) string {
+	return fmt.Sprintf("committer=%t extensions=%q languages=%q exclude=%q restrict-to=%q submodules=%t max-file-size=%q binary=%t lfs=%q worktree=%t untracked=%t worktree-author=%q pathspecs=%q files=%q ignore-filters=%t",
+		scan.UseCommitter, scan.Extensions, scan.Languages, scan.Exclude, scan.RestrictTo, scan.RecurseSubmodules,
+		scan.MaxFileSize, scan.IncludeBinary, scan.LFS, scan.Worktree, scan.IncludeUntracked, scan.WorktreeAuthor, scan.Pathspecs, scan.Files, scan.IgnoreFilters)
+}
@@ -0,0 +1,19 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalysisOptions(t *testing.T) {
+	base := AnalysisOptions(scan(t, "--files-from", "-"))
+	require.Equal(t, base, AnalysisOptions(scan(t, "--files-from", "-", "--format", "csv", "--order-by", "files")))
+	for _, args := range [][]string{
+		{"--files-from", "-", "--ignore-filters"},
+		{"--files-from", "-", "--exclude", "vendor/*"},
+		{"--files-from", "-", "--use-committer"},
+	} {
+		require.NotEqual(t, base, AnalysisOptions(scan(t, args...)), args)
+	}
+}
@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// The pseudo-commit git blame attributes uncommitted lines to.
+const (
+	NotCommittedHash   = "0000000000000000000000000000000000000000"
+	NotCommittedAuthor = "Not Committed Yet"
+	NotCommittedEmail  = "not.committed.yet"
+)
+
+// readFilePrefix reads the first 8000 bytes of a work tree file.
+func readFilePrefix(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	prefix := make([]byte, binaryPrefix)
+	n, err := f.Read(prefix)
+	if n == 0 && err != nil {
+		return nil, nil
+	}
+	return prefix[:n], nil
+}
+
+// countLines counts lines as git blame does: a last line without a newline counts too.
+func countLines(content []byte) int {
+	lines := bytes.Count(content, []byte{'\n'})
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// parseUntracked attributes all lines of an untracked file to the author of
+// uncommitted lines.
+func (p *Parser) parseUntracked(file TreeFile) error {
+	content, err := os.ReadFile(filepath.Join(file.Repo.WorkTree, file.Name))
+	if err != nil {
+		return err
+	}
+	lines := countLines(content)
+	p.addUncommitted(file, lines)
+	if p.OnHunk != nil && lines > 0 {
+		header := []string{NotCommittedHash, "1", "1", strconv.Itoa(lines)}
+		return p.emitHunk(file, LanguageOf(file.Path), header, nil)
+	}
+	return nil
+}
+
+// addUncommitted records lines of file that are not committed yet.
+func (p *Parser) addUncommitted(file TreeFile, lines int) {
+	as := p.author(p.uncommittedAuthor)
+	as.Files.Add(file.Path)
+	as.Commits.Add(NotCommittedHash)
+	as.Emails[p.uncommittedEmail] = true
+	as.LinesCnt += lines
+	as.AddCommitTime(p.Reference)
+	if lines > 0 {
+		as.AddLineAge(p.Reference, p.Reference, lines)
+		if p.FileLines != nil {
+			p.FileLines[file.Path] = map[string]int{p.uncommittedAuthor: lines}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+)
+
+// editedRepo creates a repository whose work tree has a modified file and
+// an untracked one; uncommitted lines belong to user.name Dev Person.
+func editedRepo(t *testing.T) *gittest.Repo {
+	r := gittest.New(t)
+	r.Commit("Alice", map[string]string{"a.txt": "1\n2\n3\n"})
+	r.Git("config", "user.name", "Dev Person")
+	r.Git("config", "user.email", "dev@example.com")
+	r.Write(map[string]string{"a.txt": "1\nchanged\n3\nnew\n", "new.txt": "x\ny\n"})
+	return r
+}
+
+func TestWorktree(t *testing.T) {
+	r := editedRepo(t)
+
+	// HEAD ignores the work tree
+	require.Equal(t, map[string]int{"Alice": 3}, collect(t, scan(t, "--repository", r.Dir)))
+
+	require.Equal(t, map[string]int{"Alice": 2, "Dev Person": 2},
+		collect(t, scan(t, "--repository", r.Dir, "--worktree")))
+	require.Equal(t, map[string]int{"Alice": 2, "Dev Person": 4},
+		collect(t, scan(t, "--repository", r.Dir, "--worktree", "--include-untracked")))
+	require.Equal(t, map[string]int{"Alice": 2, "Reviewer": 4},
+		collect(t, scan(t, "--repository", r.Dir, "--worktree", "--include-untracked", "--worktree-author", "Reviewer")))
+}
+
+func TestWorktreeFlagsRequireWorktree(t *testing.T) {
+	require.ErrorContains(t, ValidateFilters(scan(t, "--include-untracked")), "--include-untracked")
+	require.ErrorContains(t, ValidateFilters(scan(t, "--worktree-author", "Reviewer")), "--worktree-author")
+}
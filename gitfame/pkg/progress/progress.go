@@ -0,0 +1,159 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"io"
+	"os"
+	"time"
+)
+
+const redrawInterval = 100 * time.Millisecond
+
+// Reporter receives blame progress of a repository.
+type Reporter interface {
+	Start(repo string, total int)
+	// File is called before blaming file, when done files are already blamed.
+	File(done int, file string)
+	// Finish is called when blaming stops; err is nil if all files are blamed.
+	Finish(err error)
+}
+
+// New returns a reporter for --progress and --progress-format, or nil if progress is disabled.
+// In auto mode progress is shown only when w is a terminal.
+func New(mode, format string, w *os.File) (Reporter, error) {
+	switch format {
+	case "text", "json-lines":
+	default:
+		return nil, errs.InvalidFlag("progress-format", "unknown format %q", format)
+	}
+	switch mode {
+	case "never":
+		return nil, nil
+	case "auto":
+		if !isTerminal(w) {
+			return nil, nil
+		}
+	case "always":
+	default:
+		return nil, errs.InvalidFlag("progress", "unknown mode %q", mode)
+	}
+	if format == "json-lines" {
+		return &JSONReporter{w: w}, nil
+	}
+	return &TextReporter{w: w}, nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type state struct {
+	repo  string
+	total int
+	done  int // files blamed before the last File call
+	start time.Time
+}
+
+// rate returns throughput in files per second and the estimated time left.
+func (s *state) rate(done int) (float64, time.Duration) {
+	elapsed := time.Since(s.start)
+	if done == 0 || elapsed <= 0 {
+		return 0, 0
+	}
+	perFile := elapsed / time.Duration(done)
+	return float64(done) / elapsed.Seconds(), perFile * time.Duration(s.total-done)
+}
+
+// TextReporter redraws a single status line.
+type TextReporter struct {
+	state
+	w      io.Writer
+	drawn  time.Time
+	active bool
+}
+
+func (r *TextReporter) Start(repo string, total int) {
+	r.state = state{repo: repo, total: total, start: time.Now()}
+}
+
+func (r *TextReporter) File(done int, file string) {
+	r.done = done
+	if time.Since(r.drawn) < redrawInterval {
+		return
+	}
+	r.drawn = time.Now()
+	r.active = true
+	speed, eta := r.rate(done)
+	_, _ = fmt.Fprintf(r.w, "\r\x1b[K%s: %d/%d files, %.1f files/s, ETA %s, %s",
+		r.repo, done, r.total, speed, eta.Round(time.Second), file)
+}
+
+// Finish clears the status line; errors are reported by the caller.
+func (r *TextReporter) Finish(err error) {
+	if r.active {
+		_, _ = fmt.Fprint(r.w, "\r\x1b[K")
+		r.active = false
+	}
+}
+
+// JSONReporter writes one json object per event.
+type JSONReporter struct {
+	state
+	w io.Writer
+}
+
+type event struct {
+	Event       string  `json:"event"`
+	Repository  string  `json:"repository"`
+	Done        int     `json:"done"`
+	Total       int     `json:"total"`
+	File        string  `json:"file,omitempty"`
+	ElapsedMs   int64   `json:"elapsed_ms"`
+	FilesPerSec float64 `json:"files_per_sec"`
+	EtaMs       int64   `json:"eta_ms"`
+	Error       string  `json:"error,omitempty"`
+}
+
+func (r *JSONReporter) emit(name string, done int, file string, failure error) {
+	speed, eta := r.rate(done)
+	e := event{
+		Event:       name,
+		Repository:  r.repo,
+		Done:        done,
+		Total:       r.total,
+		File:        file,
+		ElapsedMs:   time.Since(r.start).Milliseconds(),
+		FilesPerSec: speed,
+		EtaMs:       eta.Milliseconds(),
+	}
+	if failure != nil {
+		e.Error = failure.Error()
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, _ = fmt.Fprintln(r.w, string(data))
+	}
+}
+
+func (r *JSONReporter) Start(repo string, total int) {
+	r.state = state{repo: repo, total: total, start: time.Now()}
+	r.emit("start", 0, "", nil)
+}
+
+func (r *JSONReporter) File(done int, file string) {
+	r.done = done
+	r.emit("file", done, file, nil)
+}
+
+// Finish emits "finish", or "error" with the number of files blamed before
+// the failure.
+func (r *JSONReporter) Finish(err error) {
+	if err != nil {
+		r.emit("error", r.done, "", err)
+		return
+	}
+	r.emit("finish", r.total, "", nil)
+}
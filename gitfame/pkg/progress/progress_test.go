@@ -0,0 +1,72 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// events decodes the json-lines written by a JSONReporter.
+func events(t *testing.T, out *bytes.Buffer) []event {
+	var events []event
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		var e event
+		require.NoError(t, decoder.Decode(&e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestJSONReporter(t *testing.T) {
+	var out bytes.Buffer
+	r := &JSONReporter{w: &out}
+	r.Start("repo", 2)
+	r.File(0, "a.go")
+	r.File(1, "b.go")
+	r.Finish(nil)
+
+	got := events(t, &out)
+	require.Len(t, got, 4)
+	for i, want := range []struct {
+		event string
+		done  int
+		file  string
+	}{{"start", 0, ""}, {"file", 0, "a.go"}, {"file", 1, "b.go"}, {"finish", 2, ""}} {
+		require.Equal(t, want.event, got[i].Event)
+		require.Equal(t, "repo", got[i].Repository)
+		require.Equal(t, 2, got[i].Total)
+		require.Equal(t, want.done, got[i].Done)
+		require.Equal(t, want.file, got[i].File)
+		require.Empty(t, got[i].Error)
+	}
+}
+
+func TestJSONReporterError(t *testing.T) {
+	var out bytes.Buffer
+	r := &JSONReporter{w: &out}
+	r.Start("repo", 3)
+	r.File(0, "a.go")
+	r.File(1, "b.go")
+	r.Finish(errors.New("blame b.go: exit status 128"))
+
+	got := events(t, &out)
+	require.Len(t, got, 4)
+	last := got[3]
+	require.Equal(t, "error", last.Event)
+	require.Equal(t, 1, last.Done)
+	require.Equal(t, "blame b.go: exit status 128", last.Error)
+}
+
+func TestTextReporterClearsLine(t *testing.T) {
+	var out bytes.Buffer
+	r := &TextReporter{w: &out}
+	r.Start("repo", 1)
+	r.File(0, "a.go")
+	require.Contains(t, out.String(), "repo: 0/1 files")
+	r.Finish(errors.New("failed"))
+	require.True(t, bytes.HasSuffix(out.Bytes(), []byte("\r\x1b[K")))
+}
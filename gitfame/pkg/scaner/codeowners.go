@@ -0,0 +1,83 @@
+package scaner
+
+import "github.com/spf13/cobra"
+
+// reportOnlyFlags only affect the author table and are hidden in codeowners.
+var reportOnlyFlags = []string{
+	"manifest", "by", "order-by", "format", "output", "template", "template-file", "percentages", "totals",
+	"report-skipped", "ages", "age-buckets", "columns", "top", "min-lines", "min-commits",
+}
+
+// setOwnershipFlags sets the flags shared by codeowners and codeowners check.
+func setOwnershipFlags(cmd *cobra.Command) {
+	setFlags(cmd)
+	for _, name := range reportOnlyFlags {
+		_ = cmd.Flags().MarkHidden(name)
+	}
+	cmd.Flags().StringP("handles", "", "", "YAML file mapping author names and emails to handles, e.g. 'Joe Tsai: \"@dsnet\"'")
+}
+
+func setCodeownersFlags(cmd *cobra.Command) {
+	setOwnershipFlags(cmd)
+	cmd.Flags().Float64P("share", "", 0.5, "Share of lines of a path the proposed owners must cover")
+	cmd.Flags().IntP("min-owners", "", 1, "Minimum number of owners of a path")
+	cmd.Flags().StringP("departed", "", "", "Comma separated names and emails of people never proposed as owners")
+	cmd.Flags().IntP("depth", "", 1, "Directory depth of generated rules")
+	cmd.Flags().StringP("paths", "", "", "Comma separated CODEOWNERS patterns to generate rules for instead of directories")
+}
+
+func setCheckFlags(cmd *cobra.Command) {
+	setOwnershipFlags(cmd)
+	cmd.Flags().StringP("codeowners", "", "", "CODEOWNERS file to check instead of the one in the repository at --revision")
+	cmd.Flags().Float64P("max-drift", "", 0, "Share of files allowed to drift from CODEOWNERS before failing")
+}
+
+func readCodeownersFlags(cmd *cobra.Command, s *Scaner) error {
+	if err := readFlags(cmd, s); err != nil {
+		return err
+	}
+	f := flagReader{cmd: cmd}
+	s.Handles = f.String("handles")
+	if cmd.Flags().Lookup("share") != nil {
+		s.Share = f.Float64("share")
+		s.MinOwners = f.Int("min-owners")
+		s.Departed = f.String("departed")
+		s.Depth = f.Int("depth")
+		s.Paths = f.String("paths")
+	}
+	if cmd.Flags().Lookup("max-drift") != nil {
+		s.Codeowners = f.String("codeowners")
+		s.MaxDrift = f.Float64("max-drift")
+	}
+	return f.err
+}
+
+func newCodeownersCmd(s *Scaner) *cobra.Command {
+	run := func(command string) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			s.Command = command
+			s.cmd = cmd
+			s.Pathspecs = args
+			return readCodeownersFlags(cmd, s)
+		}
+	}
+	cmd := &cobra.Command{
+		Use:   "codeowners [flags] [-- pathspec...]",
+		Short: "Generate a CODEOWNERS file from blame ownership",
+		Args:  pathspecArgs,
+		RunE:  run("codeowners"),
+	}
+	setCodeownersFlags(cmd)
+	registerCompletions(cmd)
+
+	check := &cobra.Command{
+		Use:   "check [flags] [-- pathspec...]",
+		Short: "Check a CODEOWNERS file against blame ownership",
+		Args:  pathspecArgs,
+		RunE:  run("codeowners check"),
+	}
+	setCheckFlags(check)
+	registerCompletions(check)
+	cmd.AddCommand(check)
+	return cmd
+}
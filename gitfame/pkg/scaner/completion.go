@@ -0,0 +1,16 @@
+package scaner
+
+import "github.com/spf13/cobra"
+
+// CompletionFunc returns candidate values of a flag for shell completion.
+type CompletionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// Completions maps report flags to their completion functions. The valid
+// values are defined by the parser package, which fills the map in main.
+var Completions = map[string]CompletionFunc{}
+
+func registerCompletions(cmd *cobra.Command) {
+	for name, complete := range Completions {
+		_ = cmd.RegisterFlagCompletionFunc(name, complete)
+	}
+}
@@ -1,64 +1,325 @@
 package scaner
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"io"
+	"sort"
 )
 
 type Scaner struct {
-	Repository   string
-	Revision     string
-	OrderBy      string
-	UseCommitter bool
-	Format       string
-	Extensions   string
-	Languages    string
-	Exclude      string
-	RestrictTo   string
+	Repository    string   // repository being analyzed
+	Repositories  []string // all repositories given with --repository
+	Manifest      string
+	By            string
+	Revision      string
+	OrderBy       string
+	UseCommitter  bool
+	Format        string
+	Extensions    string
+	Languages     string
+	Exclude       string
+	RestrictTo    string
+	Template      string
+	TemplateFile  string
+	Percentages   bool
+	Totals        bool
+	ReportSkipped bool
+	Columns       string
+	Ages          bool
+	AgeBuckets    string
+	Top           int
+	MinLines      int
+	MinCommits    int
+
+	RecurseSubmodules bool
+	Worktree          bool   // blame the work tree instead of --revision
+	IncludeUntracked  bool   // with Worktree, count untracked files too
+	WorktreeAuthor    string // author of uncommitted lines, empty for user.name
+	MaxFileSize       string
+	IncludeBinary     bool
+	LFS               string
+	Progress          string
+	ProgressFormat    string
+
+	Pathspecs     []string // git pathspecs given after --, relative to the repository root
+	FilesFrom     string   // file with the paths to analyze, "-" for stdin
+	Files         []string // paths read from FilesFrom
+	IgnoreFilters bool     // with FilesFrom, skip the extension, language and pattern filters
+
+	Output string // --format=sqlite: database file to write
+
+	Config  string              // --config path, empty to read .gitfame.yml of the repository
+	Aliases map[string][]string // canonical name -> names and emails of the same person
+	Teams   map[string][]string // team -> globs of names and emails of its members
+
+	TeamsFile string // --teams file overriding the teams of the configuration
+
+	Command   string // subcommand name, empty for the default report
+	Addr      string // serve: listen address
+	Root      string // serve: directory with repositories
+	MaxBlames int    // serve: limit of simultaneous git blame processes
+	CacheSize int    // serve: number of analyses kept in memory
+
+	Share     float64 // codeowners: share of lines the owners of a path must cover
+	MinOwners int     // codeowners: minimum number of owners of a path
+	Handles   string  // codeowners: file mapping names and emails to handles
+	Departed  string  // codeowners: names and emails never proposed as owners
+	Depth     int     // codeowners: directory depth of generated rules
+	Paths     string  // codeowners: patterns to generate rules for instead of directories
+
+	Codeowners string  // codeowners check: CODEOWNERS file instead of the one at the revision
+	MaxDrift   float64 // codeowners check: share of drifted files allowed
+
+	cmd *cobra.Command // parsed report command, used by SetDefaults
 }
 
 var Log *logrus.Logger
 
+// flagReader reads flag values and keeps the first error.
+type flagReader struct {
+	cmd *cobra.Command
+	err error
+}
+
+func (f *flagReader) check(name string, err error) {
+	if err != nil && f.err == nil {
+		f.err = &errs.InvalidFlagError{Flag: name, Err: err}
+	}
+}
+
+func (f *flagReader) String(name string) string {
+	value, err := f.cmd.Flags().GetString(name)
+	f.check(name, err)
+	return value
+}
+
+func (f *flagReader) StringArray(name string) []string {
+	value, err := f.cmd.Flags().GetStringArray(name)
+	f.check(name, err)
+	return value
+}
+
+func (f *flagReader) Bool(name string) bool {
+	value, err := f.cmd.Flags().GetBool(name)
+	f.check(name, err)
+	return value
+}
+
+func (f *flagReader) Float64(name string) float64 {
+	value, err := f.cmd.Flags().GetFloat64(name)
+	f.check(name, err)
+	return value
+}
+
+func (f *flagReader) Int(name string) int {
+	value, err := f.cmd.Flags().GetInt(name)
+	f.check(name, err)
+	return value
+}
+
 func setFlags(cmd *cobra.Command) {
-	cmd.Flags().StringP("repository", "r", ".", "Path to Git repository")
+	cmd.Flags().StringArrayP("repository", "r", []string{"."}, "Path to Git repository; may be repeated to aggregate several repositories")
+	cmd.Flags().StringP("manifest", "", "", "YAML file listing repositories with per-repository revisions and filters")
+	cmd.Flags().StringP("config", "", "", "Configuration file; by default .gitfame.yml at --revision of the repository is used")
+	cmd.Flags().StringP("by", "", "author", "Grouping of result rows: 'author', 'repo' or 'team'")
+	cmd.Flags().StringP("teams", "", "", "YAML file mapping teams to globs of member names and emails, used by --by=team")
 	cmd.Flags().StringP("revision", "", "HEAD", "Git revision")
-	cmd.Flags().StringP("order-by", "", "lines", "Sort results by 'lines', 'commits', or 'files'")
+	cmd.Flags().StringP("order-by", "", "lines", "Comma separated sort keys, e.g. 'files,-lines,name'; '+' and '-' prefixes set the direction")
 	cmd.Flags().BoolP("use-committer", "", false, "Use committer instead of author in calculations")
-	cmd.Flags().StringP("format", "", "tabular", "Output format: 'tabular', 'csv', 'json', 'json-lines'")
+	cmd.Flags().StringP("format", "", "tabular", "Output format: 'tabular', 'csv', 'json', 'json-lines', 'markdown', 'html', 'template', 'blame-jsonl' or 'sqlite'")
+	cmd.Flags().StringP("output", "", "", "Database file written by --format=sqlite")
 	cmd.Flags().StringP("extensions", "", "", "List of file extensions to include")
 	cmd.Flags().StringP("languages", "", "", "List of programming languages to include")
 	cmd.Flags().StringP("exclude", "", "", "Glob patterns to exclude files")
 	cmd.Flags().StringP("restrict-to", "", "", "Glob patterns to include files")
+	cmd.Flags().StringP("template", "", "", "Go text/template used with --format=template")
+	cmd.Flags().StringP("template-file", "", "", "Path to a Go text/template used with --format=template")
+	cmd.Flags().BoolP("percentages", "", false, "Add lines%, commits% and files% columns relative to repo totals")
+	cmd.Flags().BoolP("totals", "", false, "Append a summary row with repo totals")
+	cmd.Flags().BoolP("report-skipped", "", false, "With --format=json, add the numbers of skipped binary, oversized and LFS files")
+	cmd.Flags().BoolP("ages", "", false, "Add columns with the number of lines per --age-buckets bucket and the median line age in days")
+	cmd.Flags().StringP("age-buckets", "", "1m,6m,1y", "Upper bounds of line age buckets with d, w, m or y units")
+	cmd.Flags().StringP("columns", "", "", "Columns to print, e.g. 'name,email,lines,files,first_commit'")
+	cmd.Flags().StringP("files-from", "", "", "Analyze exactly the paths listed in the file, one per line; '-' reads them from stdin")
+	cmd.Flags().BoolP("ignore-filters", "", false, "With --files-from, skip --extensions, --languages, --exclude and --restrict-to")
+	cmd.Flags().BoolP("recurse-submodules", "", false, "Analyze files of submodules at the commits recorded in --revision")
+	cmd.Flags().BoolP("worktree", "", false, "Blame the working tree, attributing uncommitted lines to --worktree-author")
+	cmd.Flags().BoolP("include-untracked", "", false, "With --worktree, count untracked files that are not ignored")
+	cmd.Flags().StringP("worktree-author", "", "", "Author of uncommitted lines; defaults to user.name")
+	cmd.Flags().StringP("max-file-size", "", "0", "Skip files larger than the size, e.g. '512K' or '10M'; 0, the default, disables the limit")
+	cmd.Flags().BoolP("include-binary", "", false, "Blame binary files too")
+	cmd.Flags().StringP("lfs", "", "skip", "Git LFS pointer files: 'skip', or 'file' to count them as files of the last author without lines")
+	cmd.Flags().StringP("progress", "", "auto", "Report progress to stderr: 'auto' (only on a terminal), 'always' or 'never'")
+	cmd.Flags().StringP("progress-format", "", "text", "Progress format: 'text' or 'json-lines'")
+	cmd.Flags().IntP("top", "", 0, "Print only the first N authors")
+	cmd.Flags().IntP("min-lines", "", 0, "Skip authors with fewer lines")
+	cmd.Flags().IntP("min-commits", "", 0, "Skip authors with fewer commits")
 }
 
-func readFlags(cmd *cobra.Command, s *Scaner) {
-	s.Repository, _ = cmd.Flags().GetString("repository")
-	s.Revision, _ = cmd.Flags().GetString("revision")
-	s.OrderBy, _ = cmd.Flags().GetString("order-by")
-	s.UseCommitter, _ = cmd.Flags().GetBool("use-committer")
-	s.Format, _ = cmd.Flags().GetString("format")
-	s.Extensions, _ = cmd.Flags().GetString("extensions")
-	s.Languages, _ = cmd.Flags().GetString("languages")
-	s.Exclude, _ = cmd.Flags().GetString("exclude")
-	s.RestrictTo, _ = cmd.Flags().GetString("restrict-to")
+func readFlags(cmd *cobra.Command, s *Scaner) error {
+	f := flagReader{cmd: cmd}
+	s.Repositories = f.StringArray("repository")
+	if len(s.Repositories) > 0 {
+		s.Repository = s.Repositories[0]
+	}
+	s.Manifest = f.String("manifest")
+	if s.Manifest != "" && !cmd.Flags().Changed("repository") {
+		s.Repositories = nil
+	}
+	s.Config = f.String("config")
+	s.Output = f.String("output")
+	if f.err != nil {
+		return f.err
+	}
+	return readOptions(cmd, s)
 }
 
-func (s *Scaner) Scan(args []string) {
-	Log = logrus.New()
-	Log.SetLevel(logrus.DebugLevel)
+// readOptions reads the flags that may be set in a configuration file.
+func readOptions(cmd *cobra.Command, s *Scaner) error {
+	f := flagReader{cmd: cmd}
+	s.By = f.String("by")
+	s.TeamsFile = f.String("teams")
+	s.Revision = f.String("revision")
+	s.OrderBy = f.String("order-by")
+	s.UseCommitter = f.Bool("use-committer")
+	s.Format = f.String("format")
+	s.Extensions = f.String("extensions")
+	s.Languages = f.String("languages")
+	s.Exclude = f.String("exclude")
+	s.RestrictTo = f.String("restrict-to")
+	s.Template = f.String("template")
+	s.TemplateFile = f.String("template-file")
+	s.Percentages = f.Bool("percentages")
+	s.Totals = f.Bool("totals")
+	s.ReportSkipped = f.Bool("report-skipped")
+	s.Columns = f.String("columns")
+	s.Ages = f.Bool("ages")
+	s.AgeBuckets = f.String("age-buckets")
+	s.FilesFrom = f.String("files-from")
+	s.IgnoreFilters = f.Bool("ignore-filters")
+	s.RecurseSubmodules = f.Bool("recurse-submodules")
+	s.Worktree = f.Bool("worktree")
+	s.IncludeUntracked = f.Bool("include-untracked")
+	s.WorktreeAuthor = f.String("worktree-author")
+	s.MaxFileSize = f.String("max-file-size")
+	s.IncludeBinary = f.Bool("include-binary")
+	s.LFS = f.String("lfs")
+	s.Progress = f.String("progress")
+	s.ProgressFormat = f.String("progress-format")
+	s.Top = f.Int("top")
+	s.MinLines = f.Int("min-lines")
+	s.MinCommits = f.Int("min-commits")
+	return f.err
+}
+
+func setServeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("addr", "", ":8080", "Address to listen on")
+	cmd.Flags().StringP("root", "", ".", "Directory with local Git repositories")
+	cmd.Flags().IntP("max-blames", "", 8, "Maximum number of simultaneous git blame processes")
+	cmd.Flags().IntP("cache-size", "", 64, "Number of analyses kept in memory, the least recently used are evicted")
+}
+
+func readServeFlags(cmd *cobra.Command, s *Scaner) error {
+	f := flagReader{cmd: cmd}
+	s.Addr = f.String("addr")
+	s.Root = f.String("root")
+	s.MaxBlames = f.Int("max-blames")
+	s.CacheSize = f.Int("cache-size")
+	return f.err
+}
+
+// pathspecArgs accepts positional arguments only after --.
+func pathspecArgs(cmd *cobra.Command, args []string) error {
+	if dash := cmd.ArgsLenAtDash(); len(args) > 0 && dash != 0 {
+		return &errs.PathspecError{Pathspecs: args, Err: fmt.Errorf("unexpected argument %q, pathspecs go after --", args[0])}
+	}
+	return nil
+}
+
+func newRootCmd(s *Scaner) *cobra.Command {
 	var rootCmd = &cobra.Command{
-		Use: "gitfame",
-		Run: func(cmd *cobra.Command, args []string) {
-			readFlags(cmd, s)
+		Use:  "gitfame [flags] [-- pathspec...]",
+		Args: pathspecArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s.Command = ""
+			s.cmd = cmd
+			s.Pathspecs = args
+			return readFlags(cmd, s)
 		},
 	}
-
 	setFlags(rootCmd)
+	return rootCmd
+}
+
+// SetDefaults sets the flags that were not given on the command line,
+// e.g. from a configuration file, and reads them into s.
+func (s *Scaner) SetDefaults(values map[string]string) error {
+	if s.cmd == nil {
+		return fmt.Errorf("flags are not parsed")
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	flags := s.cmd.Flags()
+	for _, name := range names {
+		flag := flags.Lookup(name)
+		if flag == nil {
+			return errs.InvalidFlag("config", "unknown flag %q", name)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := flags.Set(name, values[name]); err != nil {
+			return errs.InvalidFlag("config", "flag %q: %w", name, err)
+		}
+	}
+	return readOptions(s.cmd, s)
+}
+
+// Parse fills s from report flags without printing usage or exiting on errors.
+func (s *Scaner) Parse(args []string) error {
+	rootCmd := newRootCmd(s)
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+// Scan parses command line arguments. Command is set to "help" if only usage was printed.
+func (s *Scaner) Scan(args []string) error {
+	Log = logrus.New()
+	Log.SetLevel(logrus.DebugLevel)
+	rootCmd := newRootCmd(s)
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve stats of local repositories over HTTP",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s.Command = cmd.Name()
+			return readServeFlags(cmd, s)
+		},
+	}
+	setServeFlags(serveCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(newCodeownersCmd(s))
+	registerCompletions(rootCmd)
 
+	rootCmd.SilenceErrors = true
 	rootCmd.SetArgs(args)
+	s.Command = "help"
 	if err := rootCmd.Execute(); err != nil {
-		Log.Fatal(err)
+		if errs.ExitCode(err) == errs.ExitInvalidFlags {
+			return err
+		}
+		return &errs.InvalidFlagError{Err: err}
 	}
+	return nil
 	//Log.Debug("repository: ", s.Repository)
 	//Log.Debug("revision: ", s.Revision)
 	//Log.Debug("orderby: ", s.OrderBy)
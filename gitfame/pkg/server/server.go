@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/parser"
+	"gitlab.com/slon/shad-go/gitfame/pkg/scaner"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+// forbiddenParams are flags that must not be controlled by HTTP clients.
+var forbiddenParams = map[string]bool{
+	"repository":      true,
+	"manifest":        true,
+	"by":              true,
+	"template-file":   true,
+	"teams":           true,
+	"config":          true,
+	"worktree":        true,
+	"files-from":      true,
+	"output":          true,
+	"progress":        true,
+	"progress-format": true,
+	"help":            true,
+}
+
+var contentTypes = map[string]string{
+	"json":       "application/json",
+	"json-lines": "application/x-ndjson",
+	"csv":        "text/csv; charset=utf-8",
+	"html":       "text/html; charset=utf-8",
+	"markdown":   "text/markdown; charset=utf-8",
+}
+
+type cacheKey struct {
+	repo     string
+	revision string // resolved commit hash
+	options  string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	done    chan struct{}
+	stats   map[string]*parser.AuthorStats
+	skipped parser.SkippedFiles
+	err     error
+}
+
+// Server serves stats of the repositories located directly in Root.
+type Server struct {
+	Root       string
+	blameSlots chan struct{}
+	analyze    func(*parser.Parser) error // blames the files of the parser
+
+	mu        sync.Mutex
+	cacheSize int
+	cache     map[cacheKey]*list.Element
+	recent    *list.List // *cacheEntry, the most recently used first
+}
+
+// New creates a server running at most maxBlames git blame processes and
+// keeping the stats of the cacheSize most recently requested analyses.
+func New(root string, maxBlames, cacheSize int) *Server {
+	return &Server{
+		Root:       root,
+		blameSlots: make(chan struct{}, maxBlames),
+		analyze:    (*parser.Parser).DoRoutine,
+		cacheSize:  cacheSize,
+		cache:      make(map[cacheKey]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/repos/")
+	name, action, _ := strings.Cut(rest, "/")
+	if !ok || action != "stats" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.handleStats(w, name, r.URL.Query())
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, name string, query url.Values) {
+	repo, err := s.repoPath(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	scan, err := parseQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scan.Repository = repo
+	hash, err := resolveRevision(repo, scan.Revision)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	scan.Revision = hash
+	if err := applyConfig(scan); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	formatter, err := parser.NewFormatter(scan)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := formatter.(parser.HunkWriter); ok {
+		http.Error(w, fmt.Sprintf("format %q is not supported by the server", scan.Format), http.StatusBadRequest)
+		return
+	}
+	if err := parser.ValidateFilters(scan); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tf, ok := formatter.(*parser.TemplateFormatter); ok {
+		if err := tf.Open(scan, []parser.RepoSpec{{Path: repo, Name: name}}); err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+	}
+
+	entry := s.stats(cacheKey{repo: repo, revision: hash, options: parser.AnalysisOptions(scan)}, scan)
+	if entry.err != nil {
+		logrus.Errorf("%s@%s: %v", name, hash, entry.err)
+		http.Error(w, entry.err.Error(), httpStatus(entry.err))
+		return
+	}
+	// aliases and teams only rename rows, so the cached stats are shared
+	stats := parser.ApplyAliases(entry.stats, scan.Aliases)
+	if scan.By == "team" {
+		stats = parser.GroupTeams(stats, scan.Teams)
+	}
+	formatter.SetSkipped(entry.skipped)
+	var buf bytes.Buffer
+	if err := formatter.Output(&buf, stats); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	contentType, ok := contentTypes[scan.Format]
+	if !ok {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// httpStatus maps errors of the analysis to response codes.
+func httpStatus(err error) int {
+	switch errs.ExitCode(err) {
+	case errs.ExitInvalidFlags:
+		return http.StatusBadRequest
+	case errs.ExitNotRepository, errs.ExitUnknownRevision:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// repoPath maps a repository name to a local directory inside Root.
+func (s *Server) repoPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid repository name %q", name)
+	}
+	path := filepath.Join(s.Root, name)
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("repository %q not found", name)
+	}
+	return path, nil
+}
+
+// parseQuery turns query parameters into command line flags, so that the API
+// accepts exactly the options of the CLI. The default format is json.
+func parseQuery(query url.Values) (*scaner.Scaner, error) {
+	args := []string{"--format=json"}
+	for key, values := range query {
+		if forbiddenParams[key] {
+			return nil, fmt.Errorf("parameter %q is not allowed", key)
+		}
+		for _, value := range values {
+			args = append(args, "--"+key+"="+value)
+		}
+	}
+	scan := &scaner.Scaner{}
+	if err := scan.Parse(args); err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// applyConfig applies .gitfame.yml of the repository at the resolved
+// revision. Its flags may not set what the query parameters can't, except
+// --by=team for the teams of the configuration.
+func applyConfig(scan *scaner.Scaner) error {
+	config, err := parser.LoadConfig(scan)
+	if err != nil {
+		return err
+	}
+	for name := range config.Flags {
+		if forbiddenParams[name] && name != "by" {
+			return errs.InvalidFlag("config", "flag %q is not allowed by the server", name)
+		}
+	}
+	if err := config.Apply(scan); err != nil {
+		return err
+	}
+	if scan.By == "repo" {
+		return errs.InvalidFlag("by", "--by=repo is not supported by the server")
+	}
+	return nil
+}
+
+func resolveRevision(repo, revision string) (string, error) {
+	if strings.HasPrefix(revision, "-") {
+		return "", fmt.Errorf("invalid revision %q", revision)
+	}
+	hash, err := parser.CreateCmdInDir(repo, "git", "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %q", revision)
+	}
+	return hash, nil
+}
+
+// stats returns the cached analysis for key, computing it at most once for concurrent requests.
+// The least recently used entries are evicted beyond cacheSize; requests
+// already waiting for an evicted entry still get its result.
+func (s *Server) stats(key cacheKey, scan *scaner.Scaner) *cacheEntry {
+	s.mu.Lock()
+	elem, ok := s.cache[key]
+	if ok {
+		s.recent.MoveToFront(elem)
+	} else {
+		elem = s.recent.PushFront(&cacheEntry{key: key, done: make(chan struct{})})
+		s.cache[key] = elem
+		for s.recent.Len() > s.cacheSize {
+			s.evict(s.recent.Back())
+		}
+	}
+	entry := elem.Value.(*cacheEntry)
+	s.mu.Unlock()
+
+	if ok {
+		<-entry.done
+		return entry
+	}
+
+	p := parser.NewParser(scan)
+	p.BlameSlots = s.blameSlots
+	entry.err = s.analyze(p)
+	entry.stats = p.Stats
+	entry.skipped = p.Skipped
+	if entry.err != nil {
+		s.mu.Lock()
+		if s.cache[key] == elem {
+			s.evict(elem)
+		}
+		s.mu.Unlock()
+	}
+	close(entry.done)
+	return entry
+}
+
+// evict removes elem from the cache; s.mu must be held.
+func (s *Server) evict(elem *list.Element) {
+	s.recent.Remove(elem)
+	delete(s.cache, elem.Value.(*cacheEntry).key)
+}
+
+// Run serves until SIGINT or SIGTERM and then shuts down gracefully,
+// letting in-flight requests finish.
+func Run(scan *scaner.Scaner) error {
+	if scan.MaxBlames <= 0 {
+		return errs.InvalidFlag("max-blames", "must be positive")
+	}
+	if scan.CacheSize <= 0 {
+		return errs.InvalidFlag("cache-size", "must be positive")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: scan.Addr, Handler: New(scan.Root, scan.MaxBlames, scan.CacheSize)}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	logrus.Infof("serving repositories from %s on %s", scan.Root, scan.Addr)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/slon/shad-go/gitfame/pkg/errs"
+	"gitlab.com/slon/shad-go/gitfame/pkg/gittest"
+	"gitlab.com/slon/shad-go/gitfame/pkg/parser"
+)
+
+// commit commits files as author and tags the commit with the lowercase name.
+func commit(r *gittest.Repo, author string, files map[string]string) {
+	r.TB.Helper()
+	r.Commit(author, files)
+	r.Git("tag", strings.ToLower(author))
+}
+
+// newRoot creates a root directory with the repository "repo": Alice writes
+// a.go, then Bob writes b.go. The commits are tagged alice and bob.
+func newRoot(t *testing.T) string {
+	root := t.TempDir()
+	r := gittest.Init(t, filepath.Join(root, "repo"))
+	commit(r, "Alice", map[string]string{"a.go": "package a\n\nfunc A() {}\n"})
+	commit(r, "Bob", map[string]string{"b.go": "package b\n"})
+	return root
+}
+
+// countingServer counts the analyses run by s; each waits for release, if not nil.
+func countingServer(s *Server, release chan struct{}) *atomic.Int32 {
+	var calls atomic.Int32
+	analyze := s.analyze
+	s.analyze = func(p *parser.Parser) error {
+		calls.Add(1)
+		if release != nil {
+			<-release
+		}
+		return analyze(p)
+	}
+	return &calls
+}
+
+func get(s *Server, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+type row struct {
+	Name  string `json:"name"`
+	Lines int    `json:"lines"`
+}
+
+func lines(t *testing.T, w *httptest.ResponseRecorder) map[string]int {
+	t.Helper()
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var rows []row
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rows))
+	lines := make(map[string]int)
+	for _, r := range rows {
+		lines[r.Name] = r.Lines
+	}
+	return lines
+}
+
+func TestStatsComputedOnce(t *testing.T) {
+	s := New(newRoot(t), 8, 16)
+	release := make(chan struct{})
+	calls := countingServer(s, release)
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 5)
+	for i := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = get(s, "/repos/repo/stats")
+		}()
+	}
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, 10*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls.Load())
+	for _, w := range responses {
+		require.Equal(t, map[string]int{"Alice": 3, "Bob": 1}, lines(t, w))
+	}
+
+	// report options share the analysis, analysis options don't
+	require.Equal(t, http.StatusOK, get(s, "/repos/repo/stats?format=csv&order-by=files").Code)
+	require.Equal(t, int32(1), calls.Load())
+	require.Equal(t, map[string]int{"Alice": 3}, lines(t, get(s, "/repos/repo/stats?exclude=b.go")))
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestStatsErrorEvicted(t *testing.T) {
+	s := New(newRoot(t), 8, 16)
+	failed := false
+	analyze := s.analyze
+	s.analyze = func(p *parser.Parser) error {
+		if !failed {
+			failed = true
+			return errors.New("blame failed")
+		}
+		return analyze(p)
+	}
+	calls := countingServer(s, nil)
+
+	w := get(s, "/repos/repo/stats")
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "blame failed")
+	require.Equal(t, map[string]int{"Alice": 3, "Bob": 1}, lines(t, get(s, "/repos/repo/stats")))
+	require.Equal(t, map[string]int{"Alice": 3, "Bob": 1}, lines(t, get(s, "/repos/repo/stats")))
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestStatsLeastRecentlyUsedEvicted(t *testing.T) {
+	s := New(newRoot(t), 8, 2)
+	calls := countingServer(s, nil)
+
+	for _, query := range []string{"revision=alice", "revision=bob", "revision=alice", "revision=main&exclude=b.go"} {
+		require.Equal(t, http.StatusOK, get(s, "/repos/repo/stats?"+query).Code, query)
+	}
+	// the request for alice made bob the least recently used entry
+	require.Equal(t, int32(3), calls.Load())
+	require.Len(t, s.cache, 2)
+	require.Equal(t, map[string]int{"Alice": 3}, lines(t, get(s, "/repos/repo/stats?revision=alice")))
+	require.Equal(t, int32(3), calls.Load())
+	// main is the same commit as bob
+	require.Equal(t, map[string]int{"Alice": 3, "Bob": 1}, lines(t, get(s, "/repos/repo/stats?revision=main")))
+	require.Equal(t, int32(4), calls.Load())
+	require.Len(t, s.cache, 2)
+}
+
+func TestForbiddenParams(t *testing.T) {
+	s := New(newRoot(t), 8, 16)
+	calls := countingServer(s, nil)
+	for param := range forbiddenParams {
+		w := get(s, "/repos/repo/stats?"+param+"=x")
+		require.Equal(t, http.StatusBadRequest, w.Code, param)
+		require.Contains(t, w.Body.String(), "parameter \""+param+"\" is not allowed")
+	}
+	require.Equal(t, int32(0), calls.Load())
+}
+
+func TestRepositoryConfig(t *testing.T) {
+	root := newRoot(t)
+	r := &gittest.Repo{TB: t, Dir: filepath.Join(root, "repo")}
+	commit(r, "Carol", map[string]string{
+		parser.ConfigFile: "exclude: [b.go]\naliases:\n  Core: [Alice, carol@example.com]\n",
+	})
+	s := New(root, 8, 16)
+	require.Equal(t, map[string]int{"Core": 3}, lines(t, get(s, "/repos/repo/stats?extensions=.go")))
+	// the configuration is read at the requested revision
+	require.Equal(t, map[string]int{"Alice": 3, "Bob": 1}, lines(t, get(s, "/repos/repo/stats?revision=bob&extensions=.go")))
+
+	commit(r, "Dave", map[string]string{parser.ConfigFile: "flags:\n  template-file: /etc/passwd\n"})
+	w := get(s, "/repos/repo/stats")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "is not allowed by the server")
+}
+
+func TestBlameSlots(t *testing.T) {
+	s := New(newRoot(t), 1, 16)
+	s.blameSlots <- struct{}{}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- get(s, "/repos/repo/stats") }()
+	select {
+	case <-done:
+		t.Fatal("request finished while all blame slots were taken")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	<-s.blameSlots
+	select {
+	case w := <-done:
+		require.Equal(t, map[string]int{"Alice": 3, "Bob": 1}, lines(t, w))
+	case <-time.After(10 * time.Second):
+		t.Fatal("request didn't finish after a blame slot was freed")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s := New(newRoot(t), 8, 16)
+	for _, tc := range []struct {
+		method, target string
+		code           int
+	}{
+		{http.MethodGet, "/repos/repo/stats", http.StatusOK},
+		{http.MethodPost, "/repos/repo/stats", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/repos/repo", http.StatusNotFound},
+		{http.MethodGet, "/other", http.StatusNotFound},
+		{http.MethodGet, "/repos/missing/stats", http.StatusNotFound},
+		{http.MethodGet, "/repos/../stats", http.StatusNotFound},
+		{http.MethodGet, "/repos/repo/stats?revision=v9.9", http.StatusNotFound},
+		{http.MethodGet, "/repos/repo/stats?revision=--all", http.StatusNotFound},
+		{http.MethodGet, "/repos/repo/stats?format=xml", http.StatusBadRequest},
+		{http.MethodGet, "/repos/repo/stats?format=blame-jsonl", http.StatusBadRequest},
+		{http.MethodGet, "/repos/repo/stats?exclude=[", http.StatusBadRequest},
+		{http.MethodGet, "/repos/repo/stats?top=x", http.StatusBadRequest},
+		{http.MethodGet, "/repos/repo/stats?format=template&template=%7B%7B.RevisionHash%7D%7D", http.StatusOK},
+		{http.MethodGet, "/repos/repo/stats?format=template&template=%7B%7B.Unknown%7D%7D", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, nil))
+		require.Equal(t, tc.code, w.Code, "%s %s: %s", tc.method, tc.target, w.Body.String())
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{errs.InvalidFlag("languages", "unknown"), http.StatusBadRequest},
+		{&errs.NotRepositoryError{Path: "repo", Err: errors.New("missing")}, http.StatusNotFound},
+		{&errs.UnknownRevisionError{Repository: "repo", Revision: "v9.9"}, http.StatusNotFound},
+		{&errs.GitError{Args: []string{"git", "blame"}, Err: errors.New("exit status 128")}, http.StatusInternalServerError},
+		{errors.New("failed"), http.StatusInternalServerError},
+	} {
+		require.Equal(t, tc.code, httpStatus(tc.err), "%v", tc.err)
+	}
+}
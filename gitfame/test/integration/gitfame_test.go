@@ -60,8 +60,11 @@ func TestGitFame(t *testing.T) {
 				CompareResults(t, tc.Expected, output, tc.Format)
 			} else {
 				require.Error(t, err)
-				_, ok := err.(*exec.ExitError)
+				exitErr, ok := err.(*exec.ExitError)
 				require.True(t, ok)
+				if tc.ExitCode != 0 {
+					require.Equal(t, tc.ExitCode, exitErr.ExitCode())
+				}
 			}
 
 			newHEADRef := GetHEADRef(t, dir)
@@ -114,11 +117,12 @@ func ReadTestCase(t *testing.T, path string) *TestCase {
 }
 
 type TestDescription struct {
-	Name   string   `yaml:"name"`
-	Args   []string `yaml:"args"`
-	Bundle string   `yaml:"bundle"`
-	Error  bool     `yaml:"error"`
-	Format string   `yaml:"format,omitempty"`
+	Name     string   `yaml:"name"`
+	Args     []string `yaml:"args"`
+	Bundle   string   `yaml:"bundle"`
+	Error    bool     `yaml:"error"`
+	ExitCode int      `yaml:"exit_code,omitempty"`
+	Format   string   `yaml:"format,omitempty"`
 }
 
 func ReadTestDescription(t *testing.T, path string) *TestDescription {